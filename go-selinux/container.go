@@ -0,0 +1,73 @@
+package selinux
+
+import "sync"
+
+// ContainerLabelOptions holds overrides for the components of the labels
+// allocated by [NewContainerLabel]. Empty fields are left at the values
+// from the container contexts file.
+type ContainerLabelOptions struct {
+	// User replaces the user of both the process and the mount label.
+	User string
+	// Role replaces the role of the process label.
+	Role string
+	// Type replaces the type of the process label.
+	Type string
+	// FileType replaces the type of the mount label.
+	FileType string
+	// Level is used as the level of both the process and the mount label,
+	// instead of a newly allocated unique MCS level. It is reserved if it is
+	// not yet; if it is (e.g. when containers in a pod share a level), the
+	// reservation belongs to whoever made it, and is not released by Close.
+	Level string
+}
+
+// ContainerLabel holds the labels of a container, as allocated by
+// [NewContainerLabel], along with the reservation of their MCS level.
+// Close must be called once the labels are no longer in use, so that the
+// level can be used by another container.
+type ContainerLabel struct {
+	// ProcessLabel is the label to run the container processes with.
+	ProcessLabel string
+	// MountLabel is the label for the container files and mounts.
+	MountLabel string
+	// ROFileLabel is the label for content shared read-only between
+	// containers. It does not carry the container level.
+	ROFileLabel string
+
+	reserved string // the label whose level is reserved by us, if any
+	once     sync.Once
+}
+
+// NewContainerLabel allocates a new set of labels for a container of the
+// given kind, using the process label for that kind from the container
+// contexts file, along with a unique MCS level, which is reserved until
+// [ContainerLabel.Close] is called. The labels are modified according to
+// opts, which may be nil.
+//
+// If SELinux is disabled, it returns a ContainerLabel with empty labels.
+func NewContainerLabel(kind ProcessKind, opts *ContainerLabelOptions) (*ContainerLabel, error) {
+	if !getEnabled() {
+		return &ContainerLabel{}, nil
+	}
+	if opts == nil {
+		opts = &ContainerLabelOptions{}
+	}
+	return newContainerLabel(kind, opts)
+}
+
+// OwnsLevel reports whether the level of the labels was reserved by
+// [NewContainerLabel] and is yet to be released by Close.
+func (c *ContainerLabel) OwnsLevel() bool {
+	return c.reserved != ""
+}
+
+// Close releases the reservation of the level of the labels, if it is
+// owned by c. It is safe to call Close more than once; calls after the
+// first one do nothing. The labels are left intact.
+func (c *ContainerLabel) Close() error {
+	c.once.Do(func() {
+		releaseLabel(c.reserved)
+		c.reserved = ""
+	})
+	return nil
+}
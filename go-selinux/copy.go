@@ -0,0 +1,226 @@
+package selinux
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/opencontainers/selinux/pkg/pwalkdir"
+)
+
+// CopyLabelMode is how [CopyTree] labels the copies.
+type CopyLabelMode int
+
+const (
+	// CopyLabelsKeep copies the labels verbatim. This is the default.
+	CopyLabelsKeep CopyLabelMode = iota
+
+	// CopyLabelsLevel copies the labels, replacing their MLS/MCS level
+	// with the one of CopyOptions.Label, same as [CopyLevel] does.
+	CopyLabelsLevel
+
+	// CopyLabelsFixed sets CopyOptions.Label on every copy.
+	CopyLabelsFixed
+)
+
+// CopyOptions are the parameters of [CopyTree].
+type CopyOptions struct {
+	// Labels is how the copies are labeled.
+	Labels CopyLabelMode
+
+	// Label is the label to take the level from (for CopyLabelsLevel),
+	// or to set (for CopyLabelsFixed).
+	Label string
+
+	// Concurrency is the number of goroutines copying files. If zero,
+	// twice the runtime.NumCPU() is used.
+	Concurrency int
+}
+
+// CopyTree copies the file tree rooted at src to dst, which must not
+// exist, preserving or transforming the SELinux labels as requested by
+// opts. A nil opts is the same as a zero CopyOptions.
+//
+// Regular files, directories and symbolic links are copied, along with
+// their permission bits; ownership and timestamps are not preserved. Any
+// other file type results in an error. Files without a label keep the
+// default one, unless CopyLabelsFixed is used.
+//
+// Files are copied in parallel, using [pwalkdir.WalkWithOptions]. Once
+// an error occurs, the copy is stopped, and dst is left incomplete.
+func CopyTree(src, dst string, opts *CopyOptions) error {
+	var o CopyOptions
+	if opts != nil {
+		o = *opts
+	}
+	switch o.Labels {
+	case CopyLabelsKeep:
+	case CopyLabelsLevel, CopyLabelsFixed:
+		if o.Label == "" {
+			return fmt.Errorf("copy %s: %w: empty label", src, ErrInvalidLabel)
+		}
+	default:
+		return fmt.Errorf("copy %s: unknown label mode %d", src, o.Labels)
+	}
+
+	c := &treeCopier{src: src, dst: dst, opts: &o}
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		// The root is processed last, so create it in advance.
+		if err = os.Mkdir(dst, 0o700); err != nil {
+			return err
+		}
+	}
+	err = pwalkdir.WalkWithOptions(context.Background(), src, c.copy, &pwalkdir.Options{Concurrency: o.Concurrency})
+	if err != nil {
+		return err
+	}
+	// Set the permissions of the directories which would have prevented
+	// copying their contents, now that it is done.
+	for _, d := range c.dirs {
+		if err = os.Chmod(d.path, d.perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dirPerm is a directory and its permission bits.
+type dirPerm struct {
+	path string
+	perm fs.FileMode
+}
+
+// treeCopier is the state of CopyTree shared by its goroutines.
+type treeCopier struct {
+	src, dst string
+	opts     *CopyOptions
+	mu       sync.Mutex
+	dirs     []dirPerm // Directories to set the permissions of.
+}
+
+// label returns the label for the copy of fpath, or an empty string if
+// the default label should be kept.
+func (c *treeCopier) label(fpath string) (string, error) {
+	if c.opts.Labels == CopyLabelsFixed {
+		return c.opts.Label, nil
+	}
+	label, err := LfileLabel(fpath)
+	if err != nil {
+		if isNoLabel(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if label == "" || c.opts.Labels == CopyLabelsKeep {
+		return label, nil
+	}
+	return replaceLevel(label, c.opts.Label)
+}
+
+// copy is the pwalkdir.WalkDirFunc copying a single file object.
+func (c *treeCopier) copy(fpath string, d fs.DirEntry, _ error) error {
+	rel, err := filepath.Rel(c.src, fpath)
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(c.dst, rel)
+	label, err := c.label(fpath)
+	if err != nil {
+		return err
+	}
+	fi, err := d.Info()
+	if err != nil {
+		return err
+	}
+	perm := fi.Mode().Perm()
+
+	switch fi.Mode().Type() {
+	case fs.ModeDir:
+		// Root was created by CopyTree.
+		if rel != "." {
+			if err = os.Mkdir(target, 0o700); err != nil {
+				return err
+			}
+		}
+		if label != "" {
+			if err = LsetFileLabel(target, label); err != nil {
+				return err
+			}
+		}
+		// Contents can only be copied into a directory writable
+		// and searchable by the owner.
+		if perm&0o700 != 0o700 && rel != "." {
+			c.mu.Lock()
+			c.dirs = append(c.dirs, dirPerm{path: target, perm: perm})
+			c.mu.Unlock()
+			return nil
+		}
+		return os.Chmod(target, perm)
+	case fs.ModeSymlink:
+		var link string
+		if link, err = os.Readlink(fpath); err != nil {
+			return err
+		}
+		if err = os.Symlink(link, target); err != nil {
+			return err
+		}
+		if label != "" {
+			return LsetFileLabel(target, label)
+		}
+		return nil
+	case 0:
+		return copyFile(fpath, target, perm, label)
+	}
+	return &os.PathError{Op: "copy", Path: fpath, Err: fmt.Errorf("unsupported file type %s", fi.Mode().Type())}
+}
+
+// copyFile copies the contents of the regular file src to the new file
+// dst, with the given permission bits and label.
+func copyFile(src, dst string, perm fs.FileMode, label string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if label != "" {
+		err = FsetFileLabel(out, label)
+	}
+	if err == nil {
+		_, err = io.Copy(out, in)
+	}
+	if err == nil {
+		err = out.Chmod(perm)
+	}
+	if cErr := out.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
+
+// replaceLevel returns label with its MLS/MCS level replaced by the one
+// of levelFrom. Unlike [CopyLevel], it does not validate the labels, nor
+// reserve the level.
+func replaceLevel(label, levelFrom string) (string, error) {
+	con, err := NewContext(label)
+	if err != nil {
+		return "", err
+	}
+	from, err := NewContext(levelFrom)
+	if err != nil {
+		return "", err
+	}
+	con["level"] = from["level"]
+	return con.Get(), nil
+}
@@ -0,0 +1,88 @@
+package selinux
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyTree(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	for _, d := range []string{"a/b", "c"} {
+		if err := os.MkdirAll(filepath.Join(src, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(src, "a/b/file"), []byte("data"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("b/file", filepath.Join(src, "a/link")); err != nil {
+		t.Fatal(err)
+	}
+	// Contents of a read-only directory can still be copied.
+	if err := os.WriteFile(filepath.Join(src, "c/ro"), nil, 0o400); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "c"), 0o500); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(src, "c"), 0o700) //nolint:errcheck // Allow cleanup.
+
+	dst := filepath.Join(t.TempDir(), "dst")
+	if err := CopyTree(src, dst, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(dst, "c"), 0o700) //nolint:errcheck // Allow cleanup.
+
+	if data, err := os.ReadFile(filepath.Join(dst, "a/b/file")); err != nil || string(data) != "data" {
+		t.Errorf("want file contents %q, got %q (err: %v)", "data", data, err)
+	}
+	if link, err := os.Readlink(filepath.Join(dst, "a/link")); err != nil || link != "b/file" {
+		t.Errorf("want link to %q, got %q (err: %v)", "b/file", link, err)
+	}
+	for p, want := range map[string]os.FileMode{
+		"a/b/file": 0o640,
+		"c":        0o500 | os.ModeDir,
+		"c/ro":     0o400,
+	} {
+		fi, err := os.Lstat(filepath.Join(dst, p))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if fi.Mode() != want {
+			t.Errorf("%s: want mode %v, got %v", p, want, fi.Mode())
+		}
+	}
+
+	if err := CopyTree(src, dst, nil); !os.IsExist(err) {
+		t.Errorf("copy to existing dst: want ErrExist, got %v", err)
+	}
+	if err := CopyTree(src, dst+"2", &CopyOptions{Labels: CopyLabelsFixed}); err == nil {
+		t.Error("want error for empty fixed label")
+	}
+
+	if !GetEnabled() {
+		return
+	}
+	const con = "system_u:object_r:container_file_t:s0:c1,c2"
+	fixed := filepath.Join(t.TempDir(), "fixed")
+	if err := CopyTree(src, fixed, &CopyOptions{Labels: CopyLabelsFixed, Label: con}); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(fixed, "c"), 0o700) //nolint:errcheck // Allow cleanup.
+	level := filepath.Join(t.TempDir(), "level")
+	if err := CopyTree(fixed, level, &CopyOptions{Labels: CopyLabelsLevel, Label: "system_u:system_r:container_t:s0:c3,c4"}); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(level, "c"), 0o700) //nolint:errcheck // Allow cleanup.
+	for _, p := range []string{".", "a/b/file", "a/link", "c/ro"} {
+		if l, _ := LfileLabel(filepath.Join(fixed, p)); l != con {
+			t.Errorf("%s: want label %q, got %q", p, con, l)
+		}
+		const want = "system_u:object_r:container_file_t:s0:c3,c4"
+		if l, _ := LfileLabel(filepath.Join(level, p)); l != want {
+			t.Errorf("%s: want label %q, got %q", p, want, l)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package selinux
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// CreateStrategy is a way to create a file system object with a label.
+type CreateStrategy int
+
+const (
+	// CreateTmpfile creates an unnamed file with O_TMPFILE in the target
+	// directory, sets its label, and then links it into the directory.
+	// Only regular files can be created this way, and not all file
+	// systems support it.
+	CreateTmpfile CreateStrategy = iota + 1
+
+	// CreateFSCreate sets the file system object creation label of a
+	// locked thread (see [WithFSCreateLabel]) while creating the object.
+	// It requires SELinux to be enabled.
+	CreateFSCreate
+
+	// CreateRelabel creates the object with the default label, and then
+	// sets its label. Unlike the other strategies, it is not atomic, so
+	// the object can be observed with a wrong label for a short time.
+	CreateRelabel
+)
+
+func (s CreateStrategy) String() string {
+	switch s {
+	case CreateTmpfile:
+		return "tmpfile"
+	case CreateFSCreate:
+		return "fscreate"
+	case CreateRelabel:
+		return "relabel"
+	}
+	return fmt.Sprintf("CreateStrategy(%d)", int(s))
+}
+
+// defaultCreateStrategies are used if no strategies are given in
+// CreateOptions.
+var defaultCreateStrategies = []CreateStrategy{CreateTmpfile, CreateFSCreate}
+
+// CreateOptions are the parameters of [CreateWithLabel] and other
+// functions creating file system objects with a label.
+type CreateOptions struct {
+	// Strategies are tried in order, until one that is supported for the
+	// object being created is found. Any other error is returned right
+	// away. If empty, CreateTmpfile and CreateFSCreate are tried, so
+	// objects are never created with a wrong label; add CreateRelabel to
+	// fall back to a non-atomic relabel instead of failing.
+	Strategies []CreateStrategy
+}
+
+// errCreateUnsupported returns an error saying that the strategy s can
+// not be used, wrapping both errors.ErrUnsupported and err (if not nil).
+func errCreateUnsupported(s CreateStrategy, err error) error {
+	if err == nil {
+		return fmt.Errorf("create strategy %s: %w", s, errors.ErrUnsupported)
+	}
+	return fmt.Errorf("create strategy %s: %w: %w", s, errors.ErrUnsupported, err)
+}
+
+// withCreateStrategies calls fn for each strategy from opts, until it
+// returns an error other than errors.ErrUnsupported.
+func withCreateStrategies(opts *CreateOptions, fn func(CreateStrategy) error) error {
+	strategies := defaultCreateStrategies
+	if opts != nil && len(opts.Strategies) > 0 {
+		strategies = opts.Strategies
+	}
+	var errs []error
+	for _, s := range strategies {
+		err := fn(s)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// CreateWithLabel creates or truncates the named file, as os.Create does,
+// and returns it open for reading and writing. A file that did not exist
+// is created with the given label, using the strategies from opts (a nil
+// opts uses the defaults; see [CreateOptions]). An existing file is
+// truncated first, and then relabeled. An empty label means the default
+// label is used.
+//
+// If SELinux is disabled, the label is ignored, and the file is created
+// as os.Create does, the same way label.Relabel does nothing then.
+func CreateWithLabel(name, label string, opts *CreateOptions) (*os.File, error) {
+	if label == "" || !GetEnabled() {
+		return os.Create(name)
+	}
+	var f *os.File
+	err := withCreateStrategies(opts, func(s CreateStrategy) (err error) {
+		f, err = createFile(name, 0o666, label, s)
+		return err
+	})
+	if !errors.Is(err, os.ErrExist) {
+		return f, err
+	}
+	f, err = os.OpenFile(name, os.O_RDWR|os.O_TRUNC, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = FsetFileLabel(f, label); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// CreateTempWithLabel creates a new temporary file with the given label,
+// as os.CreateTemp does, and returns it open for reading and writing.
+// See [CreateWithLabel] for the meaning of label and opts.
+func CreateTempWithLabel(dir, pattern, label string, opts *CreateOptions) (*os.File, error) {
+	if label == "" || !GetEnabled() {
+		return os.CreateTemp(dir, pattern)
+	}
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	var f *os.File
+	err := withCreateStrategies(opts, func(s CreateStrategy) (err error) {
+		f, err = createTempFile(dir, pattern, label, s)
+		return err
+	})
+	return f, err
+}
+
+// MkdirWithLabel creates a new directory with the specified name,
+// permission bits (before umask) and label, as os.Mkdir does. Directories
+// can not be created with CreateTmpfile. See [CreateWithLabel] for the
+// meaning of label and opts.
+func MkdirWithLabel(name string, perm os.FileMode, label string, opts *CreateOptions) error {
+	if label == "" || !GetEnabled() {
+		return os.Mkdir(name, perm)
+	}
+	return withCreateStrategies(opts, func(s CreateStrategy) error {
+		if s == CreateTmpfile {
+			return errCreateUnsupported(s, nil)
+		}
+		return mkdirLabeled(name, perm, label, s)
+	})
+}
+
+// MkdirAllWithLabel creates a directory named path, along with any
+// necessary parents, as os.MkdirAll does. Every directory it creates gets
+// the given label, while the existing ones are left intact. See
+// [MkdirWithLabel] for details.
+func MkdirAllWithLabel(path string, perm os.FileMode, label string, opts *CreateOptions) error {
+	if fi, err := os.Stat(path); err == nil {
+		if fi.IsDir() {
+			return nil
+		}
+		return &os.PathError{Op: "mkdir", Path: path, Err: syscall.ENOTDIR}
+	}
+	if parent := filepath.Dir(path); parent != path {
+		if err := MkdirAllWithLabel(parent, perm, label, opts); err != nil {
+			return err
+		}
+	}
+	if err := MkdirWithLabel(path, perm, label, opts); err != nil {
+		// The directory may have been created concurrently, or path may
+		// end with "." or a separator.
+		if fi, lErr := os.Lstat(path); lErr == nil && fi.IsDir() {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
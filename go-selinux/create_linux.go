@@ -0,0 +1,188 @@
+package selinux
+
+import (
+	"errors"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// errPatternHasSeparator is the same error os.CreateTemp returns.
+var errPatternHasSeparator = errors.New("pattern contains path separator")
+
+// createFile creates the new file name with the given permission bits
+// (before umask) and label, using strategy s. It fails if the file
+// already exists.
+func createFile(name string, perm os.FileMode, label string, s CreateStrategy) (*os.File, error) {
+	const flags = os.O_RDWR | os.O_CREATE | os.O_EXCL
+	switch s {
+	case CreateTmpfile:
+		fd, err := openTmpfile(filepath.Dir(name), perm, label)
+		if err != nil {
+			return nil, err
+		}
+		if err = linkTmpfile(fd, name); err != nil {
+			unix.Close(fd)
+			return nil, err
+		}
+		return os.NewFile(uintptr(fd), name), nil
+	case CreateFSCreate:
+		if !GetEnabled() {
+			return nil, errCreateUnsupported(s, nil)
+		}
+		var f *os.File
+		err := WithFSCreateLabel(label, func() (err error) {
+			f, err = os.OpenFile(name, flags, perm)
+			return err
+		})
+		return f, err
+	case CreateRelabel:
+		f, err := os.OpenFile(name, flags, perm)
+		if err != nil {
+			return nil, err
+		}
+		if err = FsetFileLabel(f, label); err != nil {
+			f.Close()
+			os.Remove(name)
+			return nil, err
+		}
+		return f, nil
+	}
+	return nil, errCreateUnsupported(s, nil)
+}
+
+// createTempFile creates a new temporary file in dir with the given
+// label, using strategy s. See os.CreateTemp for the meaning of pattern.
+func createTempFile(dir, pattern, label string, s CreateStrategy) (*os.File, error) {
+	switch s {
+	case CreateTmpfile:
+		if strings.ContainsRune(pattern, os.PathSeparator) {
+			return nil, &os.PathError{Op: "createtemp", Path: pattern, Err: errPatternHasSeparator}
+		}
+		prefix, suffix := pattern, ""
+		if pos := strings.LastIndexByte(pattern, '*'); pos != -1 {
+			prefix, suffix = pattern[:pos], pattern[pos+1:]
+		}
+		fd, err := openTmpfile(dir, 0o600, label)
+		if err != nil {
+			return nil, err
+		}
+		// Same number of attempts as os.CreateTemp.
+		for try := 0; ; try++ {
+			name := filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10)+suffix)
+			lErr := linkTmpfile(fd, name)
+			if lErr == nil {
+				return os.NewFile(uintptr(fd), name), nil
+			}
+			if !errors.Is(lErr, os.ErrExist) || try >= 10000 {
+				unix.Close(fd)
+				return nil, lErr
+			}
+		}
+	case CreateFSCreate:
+		if !GetEnabled() {
+			return nil, errCreateUnsupported(s, nil)
+		}
+		var f *os.File
+		err := WithFSCreateLabel(label, func() (err error) {
+			f, err = os.CreateTemp(dir, pattern)
+			return err
+		})
+		return f, err
+	case CreateRelabel:
+		f, err := os.CreateTemp(dir, pattern)
+		if err != nil {
+			return nil, err
+		}
+		if err = FsetFileLabel(f, label); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return nil, err
+		}
+		return f, nil
+	}
+	return nil, errCreateUnsupported(s, nil)
+}
+
+// mkdirLabeled creates the new directory name with the given permission
+// bits (before umask) and label, using strategy s.
+func mkdirLabeled(name string, perm os.FileMode, label string, s CreateStrategy) error {
+	switch s {
+	case CreateFSCreate:
+		if !GetEnabled() {
+			return errCreateUnsupported(s, nil)
+		}
+		return WithFSCreateLabel(label, func() error {
+			return os.Mkdir(name, perm)
+		})
+	case CreateRelabel:
+		if err := os.Mkdir(name, perm); err != nil {
+			return err
+		}
+		// Relabel via a descriptor, so a directory concurrently replaced
+		// by a symlink is not followed.
+		fd, err := unix.Open(name, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		if err == nil {
+			err = fSetFileLabel(fd, label)
+			unix.Close(fd)
+		} else {
+			err = &os.PathError{Op: "open", Path: name, Err: err}
+		}
+		if err != nil {
+			os.Remove(name)
+			var pErr *os.PathError
+			if errors.As(err, &pErr) {
+				pErr.Path = name
+			}
+		}
+		return err
+	}
+	return errCreateUnsupported(s, nil)
+}
+
+// openTmpfile creates an unnamed regular file in dir with O_TMPFILE,
+// and sets its label. If O_TMPFILE is not supported for dir, an error
+// wrapping errors.ErrUnsupported is returned.
+func openTmpfile(dir string, perm os.FileMode, label string) (int, error) {
+	fd, err := unix.Open(dir, unix.O_TMPFILE|unix.O_RDWR|unix.O_CLOEXEC, uint32(perm.Perm()))
+	if err != nil {
+		err = &os.PathError{Op: "open", Path: dir, Err: err}
+		// Kernels without O_TMPFILE support see O_DIRECTORY and fail
+		// with EISDIR, and file systems without it return EOPNOTSUPP.
+		if errors.Is(err, unix.EISDIR) || errors.Is(err, unix.EOPNOTSUPP) {
+			return -1, errCreateUnsupported(CreateTmpfile, err)
+		}
+		return -1, err
+	}
+	if err = fSetFileLabel(fd, label); err != nil {
+		unix.Close(fd)
+		var pErr *os.PathError
+		if errors.As(err, &pErr) {
+			pErr.Path = dir
+		}
+		return -1, err
+	}
+	return fd, nil
+}
+
+// linkTmpfile gives the unnamed file fd, created by openTmpfile, the
+// given name. Linking through /proc/self/fd, unlike AT_EMPTY_PATH, does
+// not require CAP_DAC_READ_SEARCH. The fd entry is looked up relative to
+// the handle returned by openProcSelfFdDir, not by path.
+func linkTmpfile(fd int, name string) error {
+	dir, err := openProcSelfFdDir()
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	old := strconv.Itoa(fd)
+	if err := unix.Linkat(int(dir.Fd()), old, unix.AT_FDCWD, name, unix.AT_SYMLINK_FOLLOW); err != nil { //#nosec G115 -- fd fits into int.
+		return &os.LinkError{Op: "linkat", Old: "/proc/self/fd/" + old, New: name, Err: err}
+	}
+	return nil
+}
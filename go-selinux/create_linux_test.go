@@ -0,0 +1,123 @@
+package selinux
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateWithLabel(t *testing.T) {
+	if !GetEnabled() {
+		t.Skip("SELinux not enabled, skipping.")
+	}
+
+	const con = "system_u:object_r:container_file_t:s0:c1,c2"
+
+	for _, s := range []CreateStrategy{CreateTmpfile, CreateFSCreate, CreateRelabel} {
+		t.Run(s.String(), func(t *testing.T) {
+			opts := &CreateOptions{Strategies: []CreateStrategy{s}}
+			dir := t.TempDir()
+			check := func(p string) {
+				t.Helper()
+				if l, err := LfileLabel(p); err != nil || l != con {
+					t.Errorf("%s: want label %q, got %q (err: %v)", p, con, l, err)
+				}
+			}
+
+			name := filepath.Join(dir, "file")
+			f, err := CreateWithLabel(name, con, opts)
+			if errors.Is(err, errors.ErrUnsupported) {
+				t.Skip(err)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+			check(name)
+
+			// An existing file is truncated and relabeled.
+			if err := os.WriteFile(name, []byte("data"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := SetFileLabel(name, "system_u:object_r:container_file_t:s0"); err != nil {
+				t.Fatal(err)
+			}
+			f, err = CreateWithLabel(name, con, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi, _ := f.Stat(); fi.Size() != 0 {
+				t.Errorf("%s: not truncated", name)
+			}
+			f.Close()
+			check(name)
+
+			f, err = CreateTempWithLabel(dir, "tmp-*.txt", con, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+			if m, _ := filepath.Match(filepath.Join(dir, "tmp-*.txt"), f.Name()); !m {
+				t.Errorf("unexpected temp file name %q", f.Name())
+			}
+			check(f.Name())
+
+			sub := filepath.Join(dir, "a", "b")
+			err = MkdirAllWithLabel(sub, 0o700, con, opts)
+			if s == CreateTmpfile {
+				if !errors.Is(err, errors.ErrUnsupported) {
+					t.Errorf("want ErrUnsupported, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(filepath.Dir(sub))
+			check(sub)
+			if err := MkdirWithLabel(sub, 0o700, con, opts); !errors.Is(err, os.ErrExist) {
+				t.Errorf("want ErrExist, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCreateWithLabelDisabled(t *testing.T) {
+	if GetEnabled() {
+		t.Skip("SELinux enabled, skipping.")
+	}
+
+	const con = "system_u:object_r:container_file_t:s0:c1,c2"
+
+	dir := t.TempDir()
+	check := func(p string) {
+		t.Helper()
+		if l, err := LfileLabel(p); err == nil && l == con {
+			t.Errorf("%s: label %q set with SELinux disabled", p, l)
+		}
+	}
+	for _, s := range []CreateStrategy{CreateTmpfile, CreateFSCreate, CreateRelabel} {
+		opts := &CreateOptions{Strategies: []CreateStrategy{s}}
+		name := filepath.Join(dir, "file-"+s.String())
+		f, err := CreateWithLabel(name, con, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		f.Close()
+		check(name)
+
+		f, err = CreateTempWithLabel(dir, "tmp-*", con, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		f.Close()
+		check(f.Name())
+
+		sub := filepath.Join(dir, "dir-"+s.String(), "sub")
+		if err := MkdirAllWithLabel(sub, 0o700, con, opts); err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		check(sub)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package selinux
+
+import "os"
+
+func createFile(name string, perm os.FileMode, _ string, _ CreateStrategy) (*os.File, error) {
+	return os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+}
+
+func createTempFile(dir, pattern, _ string, _ CreateStrategy) (*os.File, error) {
+	return os.CreateTemp(dir, pattern)
+}
+
+func mkdirLabeled(name string, perm os.FileMode, _ string, _ CreateStrategy) error {
+	return os.Mkdir(name, perm)
+}
@@ -0,0 +1,50 @@
+package selinux
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// ExecTransitionError is returned by [StartWithExecLabel] when the kernel
+// refuses to execute a command with a label, which usually means the
+// transition to the label is denied by the policy. As a plain permission
+// error, such as for a file which is not executable, can not be told
+// apart, it is only returned if a label was requested.
+type ExecTransitionError struct {
+	// Path is the executable of the command.
+	Path string
+	// Source is the label of the thread that tried to execute it.
+	Source string
+	// Target is the requested label.
+	Target string
+	// Err is the error returned by exec.Cmd.Start.
+	Err error
+}
+
+func (e *ExecTransitionError) Error() string {
+	return fmt.Sprintf("exec %s: permission denied (possibly the transition from %q to %q): %v", e.Path, e.Source, e.Target, e.Err)
+}
+
+func (e *ExecTransitionError) Unwrap() error {
+	return e.Err
+}
+
+// startWithExecLabel starts cmd with the exec attribute of the forking
+// thread set to label.
+func startWithExecLabel(cmd *exec.Cmd, label string) error {
+	if label == "" && !GetEnabled() {
+		return cmd.Start()
+	}
+	return WithExecLabel(label, func() error {
+		err := cmd.Start()
+		if err == nil || label == "" || !errors.Is(err, os.ErrPermission) {
+			return err
+		}
+		// Still on the forking thread, so the source is accurate.
+		e := &ExecTransitionError{Path: cmd.Path, Target: label, Err: err}
+		e.Source, _ = CurrentLabel()
+		return e
+	})
+}
@@ -0,0 +1,24 @@
+package selinux
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestExecTransitionError(t *testing.T) {
+	err := error(&ExecTransitionError{
+		Path:   "/bin/true",
+		Source: "unconfined_u:unconfined_r:unconfined_t:s0",
+		Target: "system_u:system_r:container_t:s0",
+		Err:    &os.PathError{Op: "fork/exec", Path: "/bin/true", Err: syscall.EACCES},
+	})
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("want %v to be os.ErrPermission", err)
+	}
+	const want = `exec /bin/true: permission denied (possibly the transition from "unconfined_u:unconfined_r:unconfined_t:s0" to "system_u:system_r:container_t:s0"): fork/exec /bin/true: permission denied`
+	if got := err.Error(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
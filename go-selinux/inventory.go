@@ -0,0 +1,144 @@
+package selinux
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/opencontainers/selinux/pkg/pwalkdir"
+)
+
+// LabelInventory is a summary of the labels in a file tree, as returned
+// by [Inventory].
+type LabelInventory struct {
+	// Labels, Types and Levels are the numbers of files per label,
+	// per type, and per MLS/MCS level, respectively.
+	Labels, Types, Levels map[string]int
+	// Total is the number of files scanned, and Unlabeled is the number
+	// of those without a label.
+	Total, Unlabeled int
+}
+
+// LabelPredicate reports whether a label, parsed into a Context, matches.
+type LabelPredicate func(con Context) bool
+
+// TypeIs returns a LabelPredicate matching labels of type t.
+func TypeIs(t string) LabelPredicate {
+	return func(con Context) bool { return con["type"] == t }
+}
+
+// UserIs returns a LabelPredicate matching labels of SELinux user u.
+func UserIs(u string) LabelPredicate {
+	return func(con Context) bool { return con["user"] == u }
+}
+
+// RoleIs returns a LabelPredicate matching labels of role r.
+func RoleIs(r string) LabelPredicate {
+	return func(con Context) bool { return con["role"] == r }
+}
+
+// LevelHasCategory returns a LabelPredicate matching labels which have
+// the category cat (such as 5 for c5) in their MLS/MCS level.
+func LevelHasCategory(cat uint) LabelPredicate {
+	return func(con Context) bool { return levelHasCategory(con["level"], cat) }
+}
+
+// AllOf returns a LabelPredicate matching labels that match all preds.
+func AllOf(preds ...LabelPredicate) LabelPredicate {
+	return func(con Context) bool {
+		for _, p := range preds {
+			if !p(con) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// scanLabels calls fn for every file object in the tree rooted at root,
+// not following symlinks, with its label (empty if it has none). fn is
+// called in parallel.
+func scanLabels(root string, fn func(fpath, label string) error) error {
+	return pwalkdir.WalkWithOptions(context.Background(), root, func(p string, _ fs.DirEntry, _ error) error {
+		label, err := LfileLabel(p)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			if !isNoLabel(err) {
+				return err
+			}
+		}
+		return fn(p, label)
+	}, nil)
+}
+
+// Inventory scans the file tree rooted at root in parallel, not following
+// symlinks, and counts the labels found.
+func Inventory(root string) (*LabelInventory, error) {
+	inv := &LabelInventory{
+		Labels: make(map[string]int),
+		Types:  make(map[string]int),
+		Levels: make(map[string]int),
+	}
+	var mu sync.Mutex
+	err := scanLabels(root, func(_, label string) error {
+		var con Context
+		if label != "" {
+			// A label that can not be parsed is only counted in Labels.
+			con, _ = NewContext(label)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		inv.Total++
+		if label == "" {
+			inv.Unlabeled++
+			return nil
+		}
+		inv.Labels[label]++
+		if t := con["type"]; t != "" {
+			inv.Types[t]++
+		}
+		if l := con["level"]; l != "" {
+			inv.Levels[l]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// FindLabels scans the file tree rooted at root in parallel, not following
+// symlinks, and calls found for every file whose label satisfies match.
+// Files without a label never match. The calls to found are serialized,
+// but come in no particular order. If found returns an error, the scan
+// is stopped and the error is returned, except for fs.SkipAll, which
+// stops the scan without an error.
+func FindLabels(root string, match LabelPredicate, found func(fpath, label string) error) error {
+	var (
+		mu      sync.Mutex
+		stopped bool
+	)
+	return scanLabels(root, func(p, label string) error {
+		if label == "" {
+			return nil
+		}
+		con, err := NewContext(label)
+		if err != nil || !match(con) {
+			return nil //nolint:nilerr // Unparsable labels do not match.
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		// Other calls may be in flight when the scan is stopped.
+		if stopped {
+			return fs.SkipAll
+		}
+		err = found(p, label)
+		stopped = err != nil
+		return err
+	})
+}
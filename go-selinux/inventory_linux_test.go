@@ -0,0 +1,107 @@
+package selinux
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestLabelPredicates(t *testing.T) {
+	con, err := NewContext("system_u:object_r:container_file_t:s0:c5,c700")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, tc := range map[string]struct {
+		pred LabelPredicate
+		want bool
+	}{
+		"type":          {TypeIs("container_file_t"), true},
+		"other type":    {TypeIs("container_t"), false},
+		"user":          {UserIs("system_u"), true},
+		"role":          {RoleIs("system_r"), false},
+		"category":      {LevelHasCategory(700), true},
+		"no category":   {LevelHasCategory(6), false},
+		"all":           {AllOf(TypeIs("container_file_t"), LevelHasCategory(5)), true},
+		"not all":       {AllOf(TypeIs("container_file_t"), LevelHasCategory(6)), false},
+		"all, no preds": {AllOf(), true},
+	} {
+		if got := tc.pred(con); got != tc.want {
+			t.Errorf("%s: want %v, got %v", name, tc.want, got)
+		}
+	}
+	if LevelHasCategory(1)(Context{}) {
+		t.Error("want no match for empty level")
+	}
+	// Categories in the high level of a range also match.
+	if !LevelHasCategory(3)(Context{"level": "s0-s0:c0.c1023"}) {
+		t.Error("want match for category in range")
+	}
+}
+
+func TestInventory(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{"a", "b", "c"} {
+		if err := os.WriteFile(filepath.Join(dir, p), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !GetEnabled() {
+		inv, err := Inventory(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inv.Total != 4 || inv.Unlabeled != 4 {
+			t.Errorf("want 4 unlabeled files, got %+v", inv)
+		}
+		return
+	}
+
+	const (
+		con1 = "system_u:object_r:container_file_t:s0:c1,c2"
+		con2 = "system_u:object_r:container_ro_file_t:s0:c1,c2"
+		con3 = "system_u:object_r:container_file_t:s0:c3,c4"
+	)
+	for p, con := range map[string]string{dir: con1, "a": con1, "b": con2, "c": con3} {
+		if p != dir {
+			p = filepath.Join(dir, p)
+		}
+		if err := SetFileLabel(p, con); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inv, err := Inventory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.Total != 4 || inv.Unlabeled != 0 ||
+		inv.Labels[con1] != 2 || inv.Labels[con2] != 1 || inv.Labels[con3] != 1 ||
+		inv.Types["container_file_t"] != 3 || inv.Types["container_ro_file_t"] != 1 ||
+		inv.Levels["s0:c1,c2"] != 3 || inv.Levels["s0:c3,c4"] != 1 {
+		t.Errorf("unexpected inventory: %+v", inv)
+	}
+
+	var found []string
+	err = FindLabels(dir, AllOf(TypeIs("container_file_t"), LevelHasCategory(1)), func(p, _ string) error {
+		found = append(found, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(found)
+	if len(found) != 2 || found[0] != dir || found[1] != filepath.Join(dir, "a") {
+		t.Errorf("unexpected matches: %q", found)
+	}
+
+	n := 0
+	err = FindLabels(dir, TypeIs("container_file_t"), func(string, string) error {
+		n++
+		return fs.SkipAll
+	})
+	if err != nil || n != 1 {
+		t.Errorf("want scan stopped after 1 match, got %d (err: %v)", n, err)
+	}
+}
@@ -0,0 +1,140 @@
+package selinux
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// journalMagic is the first record of every relabel journal.
+const journalMagic = "selinux-journal 1"
+
+// ErrJournalFormat is returned by [RestoreJournal] when the journal
+// is not in the expected format.
+var ErrJournalFormat = errors.New("invalid relabel journal format")
+
+// Journal records the labels that file objects had before they were
+// relabeled by [ChconWithJournal], so that they can later be put back
+// by [RestoreJournal].
+//
+// The on-disk format is a sequence of NUL-terminated records. Every
+// distinct label is written once and subsequently referred to by its
+// index, so a tree where most files share a label takes little more
+// space than the list of its paths.
+//
+// Each record is written to the underlying writer before the
+// corresponding file is relabeled. If the writer is durable (e.g. an
+// *os.File), a relabel that was interrupted or failed halfway through
+// can be rolled back by replaying the journal, even from a different
+// process.
+//
+// A Journal is safe for concurrent use.
+type Journal struct {
+	w      io.Writer
+	labels map[string]int
+	err    error
+	mu     sync.Mutex
+}
+
+// NewJournal returns a Journal that writes its records to w.
+func NewJournal(w io.Writer) *Journal {
+	return &Journal{w: w, labels: make(map[string]int)}
+}
+
+// Record adds an entry saying that fpath had the label prevLabel.
+// Once Record failed, all subsequent calls return the same error.
+func (j *Journal) Record(fpath, prevLabel string) error {
+	if fpath == "" {
+		return ErrEmptyPath
+	}
+	if strings.IndexByte(fpath, 0) >= 0 || strings.IndexByte(prevLabel, 0) >= 0 {
+		return fmt.Errorf("journal %q: %w", fpath, ErrJournalFormat)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.err != nil {
+		return j.err
+	}
+
+	var buf []byte
+	if len(j.labels) == 0 {
+		buf = append(buf, journalMagic...)
+		buf = append(buf, 0)
+	}
+	idx, ok := j.labels[prevLabel]
+	if !ok {
+		idx = len(j.labels)
+		j.labels[prevLabel] = idx
+		buf = append(buf, 'L')
+		buf = append(buf, prevLabel...)
+		buf = append(buf, 0)
+	}
+	buf = append(buf, 'P')
+	buf = strconv.AppendInt(buf, int64(idx), 10)
+	buf = append(buf, ' ')
+	buf = append(buf, fpath...)
+	buf = append(buf, 0)
+
+	if _, err := j.w.Write(buf); err != nil {
+		j.err = fmt.Errorf("failed to write relabel journal: %w", err)
+	}
+	return j.err
+}
+
+// journalEntry is a single path and its label, as read from a journal.
+type journalEntry struct {
+	path, label string
+}
+
+// readJournal parses the journal from r and returns its entries in the
+// order they were recorded. A truncated last record, as left behind by
+// a process that was killed while writing it, is silently dropped.
+func readJournal(r io.Reader) ([]journalEntry, error) {
+	br := bufio.NewReader(r)
+	var (
+		labels  []string
+		entries []journalEntry
+	)
+	for n := 0; ; n++ {
+		rec, err := br.ReadString(0)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to read relabel journal: %w", err)
+		}
+		rec = rec[:len(rec)-1]
+		if n == 0 {
+			if rec != journalMagic {
+				return nil, fmt.Errorf("bad header %q: %w", rec, ErrJournalFormat)
+			}
+			continue
+		}
+		if rec == "" {
+			return nil, fmt.Errorf("record %d is empty: %w", n, ErrJournalFormat)
+		}
+		switch rec[0] {
+		case 'L':
+			labels = append(labels, rec[1:])
+		case 'P':
+			idxStr, fpath, ok := strings.Cut(rec[1:], " ")
+			if !ok || fpath == "" {
+				return nil, fmt.Errorf("record %d is malformed: %w", n, ErrJournalFormat)
+			}
+			idx, err := strconv.Atoi(idxStr)
+			if err != nil || idx < 0 || idx >= len(labels) {
+				return nil, fmt.Errorf("record %d refers to unknown label %q: %w", n, idxStr, ErrJournalFormat)
+			}
+			entries = append(entries, journalEntry{path: fpath, label: labels[idx]})
+		default:
+			return nil, fmt.Errorf("record %d has unknown type %q: %w", n, rec[0], ErrJournalFormat)
+		}
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,81 @@
+package selinux
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestJournalRoundTrip(t *testing.T) {
+	records := []journalEntry{
+		{path: "/a", label: "system_u:object_r:user_home_t:s0"},
+		{path: "/a/b", label: "system_u:object_r:user_home_t:s0"},
+		{path: "/a/with space", label: "system_u:object_r:bin_t:s0"},
+		{path: "/a/new\nline", label: "system_u:object_r:user_home_t:s0"},
+	}
+
+	var buf bytes.Buffer
+	j := NewJournal(&buf)
+	for _, r := range records {
+		if err := j.Record(r.path, r.label); err != nil {
+			t.Fatalf("Record(%q): %v", r.path, err)
+		}
+	}
+
+	// Labels are only stored once.
+	if n := bytes.Count(buf.Bytes(), []byte("user_home_t")); n != 1 {
+		t.Errorf("expected label to be stored once, found %d times", n)
+	}
+
+	got, err := readJournal(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(records) {
+		t.Fatalf("want %d entries, got %d", len(records), len(got))
+	}
+	for i := range records {
+		if got[i] != records[i] {
+			t.Errorf("entry %d: want %+v, got %+v", i, records[i], got[i])
+		}
+	}
+
+	// A truncated trailing record is ignored.
+	got, err = readJournal(bytes.NewReader(buf.Bytes()[:buf.Len()-3]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(records)-1 {
+		t.Errorf("truncated journal: want %d entries, got %d", len(records)-1, len(got))
+	}
+
+	// An empty journal has no entries.
+	got, err = readJournal(bytes.NewReader(nil))
+	if err != nil || len(got) != 0 {
+		t.Errorf("empty journal: want no entries and no error, got %v, %v", got, err)
+	}
+}
+
+func TestJournalBadFormat(t *testing.T) {
+	tests := []string{
+		"not-a-journal\x00",
+		journalMagic + "\x00P0 /a\x00",
+		journalMagic + "\x00Lfoo\x00P1 /a\x00",
+		journalMagic + "\x00Lfoo\x00P0\x00",
+		journalMagic + "\x00Lfoo\x00X0 /a\x00",
+		journalMagic + "\x00\x00",
+	}
+	for _, tc := range tests {
+		if _, err := readJournal(bytes.NewBufferString(tc)); !errors.Is(err, ErrJournalFormat) {
+			t.Errorf("readJournal(%q): want ErrJournalFormat, got %v", tc, err)
+		}
+	}
+
+	j := NewJournal(&bytes.Buffer{})
+	if err := j.Record("/a\x00b", "foo"); !errors.Is(err, ErrJournalFormat) {
+		t.Errorf("Record with NUL: want ErrJournalFormat, got %v", err)
+	}
+	if err := j.Record("", "foo"); !errors.Is(err, ErrEmptyPath) {
+		t.Errorf("Record with empty path: want ErrEmptyPath, got %v", err)
+	}
+}
@@ -2,21 +2,11 @@ package label
 
 import (
 	"errors"
-	"fmt"
 	"strings"
 
 	"github.com/opencontainers/selinux/go-selinux"
 )
 
-// Valid Label Options
-var validOptions = map[string]bool{
-	"type":     true,
-	"filetype": true,
-	"user":     true,
-	"role":     true,
-	"level":    true,
-}
-
 var ErrIncompatibleLabel = errors.New("bad SELinux option: z and Z can not be used together")
 
 // InitLabels returns the process label and file labels to be used within
@@ -30,66 +20,57 @@ var ErrIncompatibleLabel = errors.New("bad SELinux option: z and Z can not be us
 //
 // If the disabled flag is passed in, the process label will not be set, but the mount label will be set
 // to the container_file label with the maximum category. This label is not usable by any confined label.
+//
+// See [ParseLabelOptions] for the format of options, and [InitLabelsWithOptions]
+// for the typed equivalent.
 func InitLabels(options []string) (plabel string, mlabel string, retErr error) {
 	if !selinux.GetEnabled() {
 		return "", "", nil
 	}
-	if len(options) > 0 && options[0] == "disable" {
-		return "", selinux.PrivContainerMountLabel(), nil
-	}
-	processLabel, mountLabel := selinux.ContainerLabels() //nolint:staticcheck // ContainerLabels will be moved to an internal package.
-	if processLabel == "" || len(options) == 0 {
-		// 1. processLabel is required; if empty, do nothing.
-		// 2. If there are no options to process, we're done.
-		return processLabel, mountLabel, nil
-	}
-	defer func() {
-		if retErr != nil {
-			selinux.ReleaseLabel(mountLabel)
-		}
-	}()
-	pcon, err := selinux.NewContext(processLabel)
+	opts, err := ParseLabelOptions(options)
 	if err != nil {
 		return "", "", err
 	}
-	mcsLevel := pcon["level"]
-	mcon, err := selinux.NewContext(mountLabel)
-	if err != nil {
+	return InitLabelsWithOptions(opts)
+}
+
+// InitLabelsWithOptions is like [InitLabels], but takes the options as
+// a LabelOptions. A nil opts is the same as no options.
+//
+// The labels altered by the options are checked against the loaded policy
+// before their level is reserved, and a wrong option is reported as a
+// *selinux.LabelComponentError, see [selinux.ValidateLabel].
+func InitLabelsWithOptions(opts *LabelOptions) (plabel string, mlabel string, retErr error) {
+	if opts == nil {
+		opts = &LabelOptions{}
+	}
+	if err := opts.Validate(); err != nil {
 		return "", "", err
 	}
-	for _, opt := range options {
-		// For backward compatibility, process "disable"
-		// even if it's not the only option.
-		if opt == "disable" {
-			selinux.ReleaseLabel(mountLabel)
-			return "", selinux.PrivContainerMountLabel(), nil
-		}
-		k, v, ok := strings.Cut(opt, ":")
-		if !ok || !validOptions[k] {
-			return "", "", fmt.Errorf("bad label option %q, valid options 'disable' or \n'user, role, level, type, filetype' followed by ':' and a value", opt)
-		}
-		if k == "filetype" {
-			mcon["type"] = v
-			continue
-		}
-		pcon[k] = v
-		if k == "level" || k == "user" {
-			mcon[k] = v
-		}
+	if !selinux.GetEnabled() {
+		return "", "", nil
 	}
-	if p := pcon.Get(); p != processLabel {
-		if pcon["level"] != mcsLevel {
-			selinux.ReleaseLabel(processLabel)
-			// Ignore ErrMCSAlreadyExists as label is user-specified and might be
-			// already reserved (e.g. when containers in a pod use the same label).
-			if err := selinux.ReserveLabelV2(p); err != nil && !errors.Is(err, selinux.ErrMCSAlreadyExists) {
-				return "", "", err
-			}
-		}
-		processLabel = p
+	if opts.Disable {
+		return "", selinux.PrivContainerMountLabel(), nil
+	}
+	kind := opts.Kind
+	if kind == 0 {
+		kind = selinux.ProcessKindRegular
 	}
-	mountLabel = mcon.Get()
-	return processLabel, mountLabel, nil
+	// The process label of the kind is used as is, so that the level is
+	// allocated (or the requested one reserved) for it. The reservation
+	// is released by the caller, using selinux.ReleaseLabel.
+	c, err := selinux.NewContainerLabel(kind, &selinux.ContainerLabelOptions{
+		User:     opts.User,
+		Role:     opts.Role,
+		Type:     opts.Type,
+		FileType: opts.FileType,
+		Level:    opts.Level,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return c.ProcessLabel, c.MountLabel, nil
 }
 
 // SetFileLabel modifies the "path" label to the specified file label
@@ -114,6 +95,15 @@ func SetFileCreateLabel(fileLabel string) error {
 //
 // The path itself is guaranteed to be relabeled last.
 func Relabel(path string, fileLabel string, shared bool) error {
+	return RelabelWithJournal(path, fileLabel, shared, nil)
+}
+
+// RelabelWithJournal is like [Relabel], but if journal is not nil, the
+// label each file had before being changed is recorded in it. Use
+// [selinux.RestoreJournal] with path as the root to put the original
+// labels back, for example when the container that used the path is
+// removed.
+func RelabelWithJournal(path string, fileLabel string, shared bool, journal *selinux.Journal) error {
 	if !selinux.GetEnabled() || fileLabel == "" {
 		return nil
 	}
@@ -127,7 +117,28 @@ func Relabel(path string, fileLabel string, shared bool) error {
 		c["level"] = "s0"
 		fileLabel = c.Get()
 	}
-	return selinux.Chcon(path, fileLabel, true)
+	return selinux.ChconWithJournal(path, fileLabel, true, journal)
+}
+
+// RelabelShared is like [Relabel], but instead of s0, it changes the MCS
+// label to the one shared by all processLabels, as computed by
+// [selinux.SharedLevel]. This allows the content to be shared by a set of
+// containers, such as the ones of a pod, and no others.
+func RelabelShared(path string, fileLabel string, processLabels []string) error {
+	if !selinux.GetEnabled() || fileLabel == "" {
+		return nil
+	}
+
+	level, err := selinux.SharedLevel(processLabels...)
+	if err != nil {
+		return err
+	}
+	c, err := selinux.NewContext(fileLabel)
+	if err != nil {
+		return err
+	}
+	c["level"] = level
+	return selinux.Chcon(path, c.Get(), true)
 }
 
 // Validate checks that the label does not include unexpected options
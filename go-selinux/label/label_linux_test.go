@@ -51,6 +51,59 @@ func TestInit(t *testing.T) {
 	}
 }
 
+func TestInitLabelsWithOptions(t *testing.T) {
+	// Options are validated even if SELinux is disabled.
+	if _, _, err := InitLabelsWithOptions(&LabelOptions{Role: "a:b"}); !errors.Is(err, ErrBadOptionValue) {
+		t.Errorf("want %v, got %v", ErrBadOptionValue, err)
+	}
+
+	needSELinux(t)
+
+	plabel, mlabel, err := InitLabelsWithOptions(&LabelOptions{
+		User:  "user_u",
+		Role:  "user_r",
+		Type:  "user_t",
+		Level: "s0:c1,c15",
+	})
+	selinux.ReleaseLabel(plabel)
+	if err != nil {
+		t.Fatalf("InitLabelsWithOptions(user) failed: %v", err)
+	}
+	if plabel != "user_u:user_r:user_t:s0:c1,c15" || (mlabel != "user_u:object_r:container_file_t:s0:c1,c15" && mlabel != "user_u:object_r:svirt_sandbox_file_t:s0:c1,c15") {
+		t.Fatalf("InitLabelsWithOptions(user) failed (plabel=%q, mlabel=%q)", plabel, mlabel)
+	}
+
+	plabel, _, err = InitLabelsWithOptions(&LabelOptions{Disable: true})
+	if err != nil || plabel != "" {
+		t.Fatalf("InitLabelsWithOptions(disable): want empty process label, got %q (err: %v)", plabel, err)
+	}
+
+	kvm, err := selinux.KVMContainerLabel()
+	selinux.ReleaseLabel(kvm)
+	if err != nil || kvm == "" {
+		t.Skip("no KVM process label in policy, skipping.")
+	}
+	plabel, _, err = InitLabelsWithOptions(&LabelOptions{Kind: selinux.ProcessKindKVM})
+	selinux.ReleaseLabel(plabel)
+	if err != nil {
+		t.Fatalf("InitLabelsWithOptions(kvm) failed: %v", err)
+	}
+	pcon, _ := selinux.NewContext(plabel)
+	kcon, _ := selinux.NewContext(kvm)
+	if pcon["type"] != kcon["type"] {
+		t.Errorf("InitLabelsWithOptions(kvm): want type %q, got %q", kcon["type"], pcon["type"])
+	}
+
+	plabel, _, err = InitLabels([]string{"kind:kvm"})
+	selinux.ReleaseLabel(plabel)
+	if err != nil {
+		t.Fatalf("InitLabels(kind:kvm) failed: %v", err)
+	}
+	if pcon, _ = selinux.NewContext(plabel); pcon["type"] != kcon["type"] {
+		t.Errorf("InitLabels(kind:kvm): want type %q, got %q", kcon["type"], pcon["type"])
+	}
+}
+
 func TestRelabel(t *testing.T) {
 	needSELinux(t)
 
@@ -87,6 +140,28 @@ func TestRelabel(t *testing.T) {
 	}
 }
 
+func TestRelabelShared(t *testing.T) {
+	needSELinux(t)
+
+	testdir := t.TempDir()
+	label := "system_u:object_r:container_file_t:s0:c1,c2"
+	procs := []string{
+		"system_u:system_r:container_t:s0:c1,c2,c3",
+		"system_u:system_r:container_t:s0:c2,c3,c4",
+	}
+	if err := RelabelShared(testdir, label, procs); err != nil {
+		t.Fatalf("RelabelShared failed: %v", err)
+	}
+	const want = "system_u:object_r:container_file_t:s0:c2,c3"
+	if l, err := selinux.FileLabel(testdir); err != nil || l != want {
+		t.Fatalf("want label %q, got %q (err: %v)", want, l, err)
+	}
+	procs = append(procs, "system_u:system_r:container_t:s0:c5,c6")
+	if err := RelabelShared(testdir, label, procs); !errors.Is(err, selinux.ErrNoSharedLevel) {
+		t.Fatalf("want %v, got %v", selinux.ErrNoSharedLevel, err)
+	}
+}
+
 func TestValidate(t *testing.T) {
 	if err := Validate("zZ"); !errors.Is(err, ErrIncompatibleLabel) {
 		t.Fatalf("Expected incompatible error, got %v", err)
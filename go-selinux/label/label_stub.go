@@ -2,6 +2,8 @@
 
 package label
 
+import "github.com/opencontainers/selinux/go-selinux"
+
 // InitLabels returns the process label and file labels to be used within
 // the container.  A list of options can be passed into this function to alter
 // the labels.
@@ -9,6 +11,10 @@ func InitLabels([]string) (string, string, error) {
 	return "", "", nil
 }
 
+func InitLabelsWithOptions(*LabelOptions) (string, string, error) {
+	return "", "", nil
+}
+
 func SetFileLabel(string, string) error {
 	return nil
 }
@@ -21,6 +27,14 @@ func Relabel(string, string, bool) error {
 	return nil
 }
 
+func RelabelWithJournal(string, string, bool, *selinux.Journal) error {
+	return nil
+}
+
+func RelabelShared(string, string, []string) error {
+	return nil
+}
+
 // DisableSecOpt returns a security opt that can disable labeling
 // support for future container processes
 func DisableSecOpt() []string {
@@ -35,12 +35,24 @@ func TestInit(t *testing.T) {
 	}
 }
 
+func TestInitLabelsWithOptions(t *testing.T) {
+	if _, _, err := InitLabelsWithOptions(&LabelOptions{Type: "user_t"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestRelabel(t *testing.T) {
 	if err := Relabel("/etc", testLabel, false); err != nil {
 		t.Fatalf("Relabel /etc succeeded")
 	}
 }
 
+func TestRelabelShared(t *testing.T) {
+	if err := RelabelShared("/etc", testLabel, []string{testLabel}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestCheckLabelCompile(t *testing.T) {
 	if _, _, err := InitLabels(nil); err != nil {
 		t.Fatal(err)
@@ -0,0 +1,160 @@
+package label
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/opencontainers/selinux/go-selinux"
+)
+
+var (
+	// ErrUnknownOption is returned (wrapped in an *OptionError) for a
+	// label option which is not known.
+	ErrUnknownOption = errors.New("valid options are 'disable' or 'user', 'role', 'level', 'type', 'filetype', 'kind' followed by ':' and a value")
+	// ErrBadOptionValue is returned (wrapped in an *OptionError) for a
+	// label option with a value which can not be used in a label.
+	ErrBadOptionValue = errors.New("value must not contain ':'")
+	// ErrUnknownKind is returned (wrapped in an *OptionError) for a kind
+	// option naming a process kind which is not known, see
+	// [selinux.RegisterProcessKind].
+	ErrUnknownKind = errors.New("unknown process kind")
+	// ErrConflictingOption is returned (wrapped in an *OptionError) for a
+	// label option which can not be combined with the others.
+	ErrConflictingOption = errors.New("label=disable can not be combined with other label options")
+)
+
+// OptionError describes an invalid label option.
+type OptionError struct {
+	// Option is the invalid option, in the "key:value" format.
+	Option string
+	Err    error
+}
+
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("bad label option %q: %v", e.Option, e.Err)
+}
+
+func (e *OptionError) Unwrap() error {
+	return e.Err
+}
+
+// LabelOptions are the options altering the labels returned by
+// [InitLabelsWithOptions]. They are the typed equivalent of the
+// "key:value" options accepted by [InitLabels].
+type LabelOptions struct {
+	// User, Role and Type replace the respective components of the
+	// process label. User is also set in the mount label.
+	User, Role, Type string
+	// FileType replaces the type of the mount label.
+	FileType string
+	// Level replaces the MLS/MCS level of both labels. It is reserved,
+	// but not checked to be unique.
+	Level string
+	// Disable, if set, disables labeling of the process. It can not be
+	// combined with any other option. See [InitLabels].
+	Disable bool
+	// Kind selects the process label the labels are allocated from,
+	// before its type is replaced by Type, if any. The zero value is the
+	// same as selinux.ProcessKindRegular. Its "key:value" equivalent is
+	// "kind:NAME", where NAME is the name of the kind.
+	Kind selinux.ProcessKind
+}
+
+// ParseLabelOptions parses the "key:value" options accepted by
+// [InitLabels]. If an option is given more than once, the last one wins.
+// For backward compatibility, "disable" overrides all other options, and
+// options after it are not parsed. An invalid option is reported as an
+// *[OptionError].
+func ParseLabelOptions(options []string) (*LabelOptions, error) {
+	o := &LabelOptions{}
+	for _, opt := range options {
+		if opt == "disable" {
+			return &LabelOptions{Disable: true}, nil
+		}
+		k, v, ok := strings.Cut(opt, ":")
+		if !ok {
+			return nil, &OptionError{Option: opt, Err: ErrUnknownOption}
+		}
+		switch k {
+		case "user":
+			o.User = v
+		case "role":
+			o.Role = v
+		case "type":
+			o.Type = v
+		case "filetype":
+			o.FileType = v
+		case "level":
+			o.Level = v
+		case "kind":
+			k, ok := selinux.ProcessKindByName(v)
+			if !ok {
+				return nil, &OptionError{Option: opt, Err: ErrUnknownKind}
+			}
+			o.Kind = k
+		default:
+			return nil, &OptionError{Option: opt, Err: ErrUnknownOption}
+		}
+	}
+	return o, nil
+}
+
+// Strings returns o in the "key:value" format accepted by [InitLabels],
+// so that ParseLabelOptions(o.Strings()) returns the same options. If
+// Disable is set, only "disable" is returned; such options are rejected
+// by [LabelOptions.Validate] if any other option is set.
+func (o *LabelOptions) Strings() []string {
+	if o.Disable {
+		return []string{"disable"}
+	}
+	var kind string
+	if o.Kind != 0 {
+		kind = o.Kind.String()
+	}
+	var opts []string
+	for _, kv := range [...]struct{ k, v string }{
+		{"user", o.User},
+		{"role", o.Role},
+		{"type", o.Type},
+		{"level", o.Level},
+		{"filetype", o.FileType},
+		{"kind", kind},
+	} {
+		if kv.v != "" {
+			opts = append(opts, kv.k+":"+kv.v)
+		}
+	}
+	return opts
+}
+
+// Validate checks that the options can be used in a label, and that
+// Disable is not combined with any other option, as it would be lost by
+// [LabelOptions.Strings]. An invalid option is reported as an
+// *[OptionError].
+func (o *LabelOptions) Validate() error {
+	if o.Disable {
+		rest := *o
+		rest.Disable = false
+		if other := rest.Strings(); len(other) > 0 {
+			return &OptionError{Option: other[0], Err: ErrConflictingOption}
+		}
+		return nil
+	}
+	for _, kv := range [...]struct{ k, v string }{
+		{"user", o.User},
+		{"role", o.Role},
+		{"type", o.Type},
+		{"filetype", o.FileType},
+	} {
+		if strings.Contains(kv.v, ":") {
+			return &OptionError{Option: kv.k + ":" + kv.v, Err: ErrBadOptionValue}
+		}
+	}
+	if o.Kind != 0 {
+		if k, ok := selinux.ProcessKindByName(o.Kind.String()); !ok || k != o.Kind {
+			return &OptionError{Option: "kind:" + o.Kind.String(), Err: ErrUnknownKind}
+		}
+	}
+	return nil
+}
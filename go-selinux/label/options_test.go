@@ -0,0 +1,90 @@
+package label
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/selinux/go-selinux"
+)
+
+func TestParseLabelOptions(t *testing.T) {
+	tests := []struct {
+		in   []string
+		opts LabelOptions
+		out  []string // If different from in.
+	}{
+		{},
+		{
+			in:   []string{"user:user_u", "role:user_r", "type:user_t", "level:s0:c1,c15", "filetype:user_file_t"},
+			opts: LabelOptions{User: "user_u", Role: "user_r", Type: "user_t", Level: "s0:c1,c15", FileType: "user_file_t"},
+		},
+		{
+			in:   []string{"type:a_t", "level:s0", "type:b_t"},
+			opts: LabelOptions{Type: "b_t", Level: "s0"},
+			out:  []string{"type:b_t", "level:s0"},
+		},
+		{
+			in:   []string{"type:a_t", "kind:kvm"},
+			opts: LabelOptions{Type: "a_t", Kind: selinux.ProcessKindKVM},
+		},
+		{
+			in:   []string{"type:a_t", "disable", "bad"},
+			opts: LabelOptions{Disable: true},
+			out:  []string{"disable"},
+		},
+	}
+	for _, tc := range tests {
+		opts, err := ParseLabelOptions(tc.in)
+		if err != nil {
+			t.Errorf("%q: %v", tc.in, err)
+			continue
+		}
+		if *opts != tc.opts {
+			t.Errorf("%q: want %+v, got %+v", tc.in, tc.opts, *opts)
+		}
+		want := tc.out
+		if want == nil {
+			want = tc.in
+		}
+		if got := opts.Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: want strings %q, got %q", tc.in, want, got)
+		}
+	}
+
+	for _, bad := range [][]string{{"user"}, {"type:a_t", "class:kvm"}, {"disable:yes"}} {
+		_, err := ParseLabelOptions(bad)
+		var oErr *OptionError
+		if !errors.As(err, &oErr) || !errors.Is(err, ErrUnknownOption) {
+			t.Errorf("%q: want OptionError wrapping ErrUnknownOption, got %v", bad, err)
+			continue
+		}
+		if oErr.Option != bad[len(bad)-1] {
+			t.Errorf("%q: want invalid option %q, got %q", bad, bad[len(bad)-1], oErr.Option)
+		}
+	}
+	if _, err := ParseLabelOptions([]string{"kind:nosuchkind"}); !errors.Is(err, ErrUnknownKind) {
+		t.Errorf("want %v, got %v", ErrUnknownKind, err)
+	}
+}
+
+func TestLabelOptionsValidate(t *testing.T) {
+	if err := (&LabelOptions{Type: "a_t", Level: "s0:c1,c2", Kind: selinux.ProcessKindKVM}).Validate(); err != nil {
+		t.Error(err)
+	}
+	err := (&LabelOptions{Type: "a_t", FileType: "x:y"}).Validate()
+	var oErr *OptionError
+	if !errors.As(err, &oErr) || oErr.Option != "filetype:x:y" || !errors.Is(err, ErrBadOptionValue) {
+		t.Errorf("want OptionError for filetype, got %v", err)
+	}
+	if err := (&LabelOptions{Kind: selinux.ProcessKind(1000)}).Validate(); !errors.Is(err, ErrUnknownKind) {
+		t.Errorf("want %v, got %v", ErrUnknownKind, err)
+	}
+	if err := (&LabelOptions{Disable: true}).Validate(); err != nil {
+		t.Error(err)
+	}
+	err = (&LabelOptions{Disable: true, Level: "s0:c1,c2"}).Validate()
+	if !errors.As(err, &oErr) || oErr.Option != "level:s0:c1,c2" || !errors.Is(err, ErrConflictingOption) {
+		t.Errorf("want OptionError for level, got %v", err)
+	}
+}
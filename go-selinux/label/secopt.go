@@ -0,0 +1,121 @@
+package label
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/opencontainers/selinux/go-selinux"
+)
+
+var (
+	// ErrDuplicateOption is returned (wrapped in an *OptionError) for a
+	// security option that is given more than once.
+	ErrDuplicateOption = errors.New("option given more than once")
+	// ErrEmptyOptionValue is returned (wrapped in an *OptionError) for a
+	// security option with an empty value, such as "label=type:".
+	ErrEmptyOptionValue = errors.New("value must not be empty")
+)
+
+// SecurityOptions are the SELinux options of a container, as given by
+// Docker or Podman "label=..." security options (--security-opt).
+type SecurityOptions struct {
+	LabelOptions
+	// Nested, if set, allows the container to run containers itself
+	// (label=nested).
+	Nested bool
+}
+
+// ParseSecurityOptions parses the security options of a container. The
+// following ones are recognized:
+//
+//	label=disable
+//	label=nested
+//	label=user:USER
+//	label=role:ROLE
+//	label=type:TYPE
+//	label=filetype:TYPE
+//	label=level:LEVEL
+//	label=kind:KIND
+//
+// The legacy "label:..." form is accepted as well. Other security options,
+// such as "seccomp=...", are ignored. An option given more than once, an
+// unknown, empty or invalid label option (including a bare "label"), or
+// label=disable combined with other label options, is reported as an
+// *[OptionError].
+func ParseSecurityOptions(secOpts []string) (*SecurityOptions, error) {
+	o := &SecurityOptions{}
+	seen := make(map[string]bool)
+	var other string // Any label option other than label=disable.
+	for _, secOpt := range secOpts {
+		key, val, ok := strings.Cut(secOpt, "=")
+		if !ok {
+			key, val, ok = strings.Cut(secOpt, ":")
+		}
+		if key != "label" {
+			continue
+		}
+		if !ok {
+			return nil, &OptionError{Option: secOpt, Err: ErrUnknownOption}
+		}
+		k, v, hasValue := strings.Cut(val, ":")
+		if seen[k] {
+			return nil, &OptionError{Option: secOpt, Err: ErrDuplicateOption}
+		}
+		seen[k] = true
+		if hasValue && v == "" {
+			return nil, &OptionError{Option: secOpt, Err: ErrEmptyOptionValue}
+		}
+		switch {
+		case val == "disable":
+			o.Disable = true
+		case val == "nested":
+			o.Nested = true
+		case k == "user" && hasValue:
+			o.User = v
+		case k == "role" && hasValue:
+			o.Role = v
+		case k == "type" && hasValue:
+			o.Type = v
+		case k == "filetype" && hasValue:
+			o.FileType = v
+		case k == "level" && hasValue:
+			o.Level = v
+		case k == "kind" && hasValue:
+			kind, known := selinux.ProcessKindByName(v)
+			if !known {
+				return nil, &OptionError{Option: secOpt, Err: ErrUnknownKind}
+			}
+			o.Kind = kind
+		default:
+			return nil, &OptionError{Option: secOpt, Err: ErrUnknownOption}
+		}
+		if val != "disable" && other == "" {
+			other = secOpt
+		}
+	}
+	if o.Disable && other != "" {
+		return nil, &OptionError{Option: other, Err: ErrConflictingOption}
+	}
+	// Disable, if set, is the only option, so Validate checks all the values.
+	if err := o.LabelOptions.Validate(); err != nil {
+		var oErr *OptionError
+		if errors.As(err, &oErr) {
+			oErr.Option = "label=" + oErr.Option
+		}
+		return nil, err
+	}
+	return o, nil
+}
+
+// SecurityOpts returns o as "label=..." security options, in the format
+// accepted by [ParseSecurityOptions].
+func (o *SecurityOptions) SecurityOpts() []string {
+	var secOpts []string
+	for _, opt := range o.Strings() {
+		secOpts = append(secOpts, "label="+opt)
+	}
+	if o.Nested && !o.Disable {
+		secOpts = append(secOpts, "label=nested")
+	}
+	return secOpts
+}
@@ -0,0 +1,86 @@
+package label
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/selinux/go-selinux"
+)
+
+func TestParseSecurityOptions(t *testing.T) {
+	tests := []struct {
+		in   []string
+		opts SecurityOptions
+		out  []string // If different from in.
+	}{
+		{},
+		{
+			in:   []string{"seccomp=unconfined", "label=disable", "no-new-privileges"},
+			opts: SecurityOptions{LabelOptions: LabelOptions{Disable: true}},
+			out:  []string{"label=disable"},
+		},
+		{
+			in: []string{"label=user:user_u", "label=role:user_r", "label=type:user_t", "label=level:s0:c1,c2", "label=filetype:user_file_t", "label=nested"},
+			opts: SecurityOptions{
+				LabelOptions: LabelOptions{User: "user_u", Role: "user_r", Type: "user_t", Level: "s0:c1,c2", FileType: "user_file_t"},
+				Nested:       true,
+			},
+		},
+		{
+			in:   []string{"label:type:container_runtime_t"},
+			opts: SecurityOptions{LabelOptions: LabelOptions{Type: "container_runtime_t"}},
+			out:  []string{"label=type:container_runtime_t"},
+		},
+		{
+			in:   []string{"label=kind:init", "label=level:s0:c3,c4"},
+			opts: SecurityOptions{LabelOptions: LabelOptions{Level: "s0:c3,c4", Kind: selinux.ProcessKindInit}},
+			out:  []string{"label=level:s0:c3,c4", "label=kind:init"},
+		},
+	}
+	for _, tc := range tests {
+		opts, err := ParseSecurityOptions(tc.in)
+		if err != nil {
+			t.Errorf("%q: %v", tc.in, err)
+			continue
+		}
+		if *opts != tc.opts {
+			t.Errorf("%q: want %+v, got %+v", tc.in, tc.opts, *opts)
+		}
+		want := tc.out
+		if want == nil {
+			want = tc.in
+		}
+		if got := opts.SecurityOpts(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: want %q, got %q", tc.in, want, got)
+		}
+	}
+
+	for _, tc := range []struct {
+		in     []string
+		option string
+		err    error
+	}{
+		{[]string{"label=type:a_t", "label=type:b_t"}, "label=type:b_t", ErrDuplicateOption},
+		{[]string{"label=nested", "label=nested"}, "label=nested", ErrDuplicateOption},
+		{[]string{"label=kind:nosuchkind"}, "label=kind:nosuchkind", ErrUnknownKind},
+		{[]string{"label=class:kvm"}, "label=class:kvm", ErrUnknownOption},
+		{[]string{"label=type"}, "label=type", ErrUnknownOption},
+		{[]string{"label=disable:yes"}, "label=disable:yes", ErrUnknownOption},
+		{[]string{"label"}, "label", ErrUnknownOption},
+		{[]string{"label=type:"}, "label=type:", ErrEmptyOptionValue},
+		{[]string{"label:level:"}, "label:level:", ErrEmptyOptionValue},
+		{[]string{"label=role:a:b"}, "label=role:a:b", ErrBadOptionValue},
+		{[]string{"label=level:s0", "label=disable"}, "label=level:s0", ErrConflictingOption},
+	} {
+		_, err := ParseSecurityOptions(tc.in)
+		var oErr *OptionError
+		if !errors.As(err, &oErr) || !errors.Is(err, tc.err) {
+			t.Errorf("%q: want OptionError wrapping %v, got %v", tc.in, tc.err, err)
+			continue
+		}
+		if oErr.Option != tc.option {
+			t.Errorf("%q: want invalid option %q, got %q", tc.in, tc.option, oErr.Option)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package selinux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultTransSocket is the path of the mcstransd socket.
+	DefaultTransSocket = "/var/run/setrans/.setrans-unix"
+	// DefaultTransTimeout is the default timeout of a request to mcstransd.
+	DefaultTransTimeout = 2 * time.Second
+)
+
+// Request types of the mcstransd protocol.
+const (
+	transRawToTrans  uint32 = 2
+	transTransToRaw  uint32 = 3
+	transRawToColor  uint32 = 4
+	transMaxDataSize        = 8192
+)
+
+// ErrTransUnavailable is returned by [TransClient.RawToColor] if mcstransd
+// is not running.
+var ErrTransUnavailable = errors.New("mcstransd is not available")
+
+// TransClient translates the MLS/MCS levels of contexts between their raw
+// and human-readable forms using mcstransd, the translations of which are
+// authoritative where it is running.
+//
+// The zero value is ready to use.
+type TransClient struct {
+	// Socket is the path of the mcstransd socket. If empty,
+	// [DefaultTransSocket] is used.
+	Socket string
+	// Timeout is the timeout of each request, including connecting to
+	// mcstransd. If zero, [DefaultTransTimeout] is used.
+	Timeout time.Duration
+	// Fallback, if not nil, is used to translate levels if mcstransd is
+	// not running. Otherwise, contexts are returned as is in that case.
+	Fallback *Translator
+}
+
+// RawToTrans returns context with its level translated to the
+// human-readable form.
+func (c *TransClient) RawToTrans(context string) (string, error) {
+	return c.translate(transRawToTrans, context)
+}
+
+// TransToRaw returns context with its level translated to the raw form.
+func (c *TransClient) TransToRaw(context string) (string, error) {
+	return c.translate(transTransToRaw, context)
+}
+
+// RawToColor returns the colors for context, as defined by the
+// secolor.conf(5) file of mcstransd. If mcstransd is not running,
+// [ErrTransUnavailable] is returned.
+func (c *TransClient) RawToColor(context string) (string, error) {
+	return c.request(transRawToColor, context)
+}
+
+func (c *TransClient) translate(function uint32, context string) (string, error) {
+	out, err := c.request(function, context)
+	if !errors.Is(err, ErrTransUnavailable) {
+		return out, err
+	}
+	if c.Fallback == nil {
+		return context, nil
+	}
+
+	// Translate the level, if any, using the fallback translator.
+	con := strings.SplitN(context, ":", 4)
+	if len(con) < 4 {
+		return context, nil
+	}
+	if function == transRawToTrans {
+		con[3], err = c.Fallback.RawToTrans(con[3])
+	} else {
+		con[3], err = c.Fallback.TransToRaw(con[3])
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(con, ":"), nil
+}
+
+// request sends a request to mcstransd and returns the response data. If
+// mcstransd can not be connected to, the error wraps ErrTransUnavailable.
+func (c *TransClient) request(function uint32, data string) (string, error) {
+	if len(data) >= transMaxDataSize {
+		return "", fmt.Errorf("mcstransd request failed: %q is too long", data)
+	}
+	socket := c.Socket
+	if socket == "" {
+		socket = DefaultTransSocket
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTransTimeout
+	}
+
+	conn, err := net.DialTimeout("unix", socket, timeout)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrTransUnavailable, err)
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", err
+	}
+
+	// A request is made of the request type and the sizes of the two
+	// NUL-terminated strings that follow, of which only the first one
+	// is used here.
+	var req bytes.Buffer
+	for _, v := range []uint32{function, uint32(len(data) + 1), 1} { //#nosec G115 -- data is short.
+		_ = binary.Write(&req, binary.NativeEndian, v)
+	}
+	req.WriteString(data)
+	req.Write([]byte{0, 0})
+	if _, err = conn.Write(req.Bytes()); err != nil {
+		return "", fmt.Errorf("mcstransd request failed: %w", err)
+	}
+
+	// A response is made of the request type, the size of the
+	// NUL-terminated data that follows, and the result.
+	var resp struct {
+		Function uint32
+		Size     uint32
+		Ret      int32
+	}
+	if err = binary.Read(conn, binary.NativeEndian, &resp); err != nil {
+		return "", fmt.Errorf("mcstransd response failed: %w", err)
+	}
+	if resp.Function != function || resp.Size > transMaxDataSize {
+		return "", fmt.Errorf("mcstransd response failed: invalid header %+v", resp)
+	}
+	out := make([]byte, resp.Size)
+	if _, err = io.ReadFull(conn, out); err != nil {
+		return "", fmt.Errorf("mcstransd response failed: %w", err)
+	}
+	if resp.Ret < 0 {
+		return "", fmt.Errorf("mcstransd failed to translate %q", data)
+	}
+	if len(out) == 0 || out[len(out)-1] != 0 {
+		return "", errors.New("mcstransd response failed: data not NUL-terminated")
+	}
+	return string(out[:len(out)-1]), nil
+}
@@ -0,0 +1,126 @@
+package selinux
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveTrans runs a stand-in for mcstransd on a temporary socket, which
+// translates the s0 level only, and returns the path of the socket.
+func serveTrans(t *testing.T, stall bool) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "setrans.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, aErr := l.Accept()
+			if aErr != nil {
+				return
+			}
+			go handleTrans(conn, stall)
+		}
+	}()
+	return socket
+}
+
+func handleTrans(conn net.Conn, stall bool) {
+	defer conn.Close()
+	var hdr [3]uint32
+	if err := binary.Read(conn, binary.NativeEndian, &hdr); err != nil {
+		return
+	}
+	data := make([]byte, hdr[1]+hdr[2])
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return
+	}
+	if stall {
+		_, _ = io.Copy(io.Discard, conn)
+		return
+	}
+	in := strings.TrimRight(string(data[:hdr[1]]), "\x00")
+
+	var (
+		out string
+		ret int32
+	)
+	switch hdr[0] {
+	case transRawToTrans:
+		out = strings.Replace(in, ":s0", ":SystemLow", 1)
+	case transTransToRaw:
+		out = strings.Replace(in, ":SystemLow", ":s0", 1)
+	case transRawToColor:
+		out = "#000000 #ffffff #000000 #ffffff #000000 #ffffff #000000 #ffffff"
+	}
+	if strings.HasPrefix(in, "bad") {
+		out, ret = "", -1
+	}
+	resp := []any{hdr[0], uint32(len(out) + 1), ret, []byte(out + "\x00")}
+	for _, v := range resp {
+		_ = binary.Write(conn, binary.NativeEndian, v)
+	}
+}
+
+func TestTransClient(t *testing.T) {
+	c := &TransClient{Socket: serveTrans(t, false)}
+	const raw, trans = "system_u:object_r:etc_t:s0", "system_u:object_r:etc_t:SystemLow"
+
+	if got, err := c.RawToTrans(raw); err != nil || got != trans {
+		t.Errorf("RawToTrans: want %q, got %q (err: %v)", trans, got, err)
+	}
+	if got, err := c.TransToRaw(trans); err != nil || got != raw {
+		t.Errorf("TransToRaw: want %q, got %q (err: %v)", raw, got, err)
+	}
+	if got, err := c.RawToColor(raw); err != nil || len(strings.Fields(got)) != 8 {
+		t.Errorf("RawToColor: unexpected colors %q (err: %v)", got, err)
+	}
+	if _, err := c.RawToTrans("bad:context"); err == nil {
+		t.Error("expected error for failed translation, got nil")
+	}
+	if _, err := c.RawToTrans(strings.Repeat("x", transMaxDataSize)); err == nil {
+		t.Error("expected error for too long context, got nil")
+	}
+}
+
+func TestTransClientTimeout(t *testing.T) {
+	c := &TransClient{Socket: serveTrans(t, true), Timeout: 50 * time.Millisecond}
+	_, err := c.RawToTrans("system_u:object_r:etc_t:s0")
+	var nErr net.Error
+	if !errors.As(err, &nErr) || !nErr.Timeout() {
+		t.Errorf("want timeout error, got %v", err)
+	}
+}
+
+func TestTransClientUnavailable(t *testing.T) {
+	c := &TransClient{Socket: filepath.Join(t.TempDir(), "none.sock")}
+	const raw = "system_u:object_r:etc_t:s0:c1"
+
+	if got, err := c.RawToTrans(raw); err != nil || got != raw {
+		t.Errorf("RawToTrans: want %q, got %q (err: %v)", raw, got, err)
+	}
+	if _, err := c.RawToColor(raw); !errors.Is(err, ErrTransUnavailable) {
+		t.Errorf("RawToColor: want %v, got %v", ErrTransUnavailable, err)
+	}
+
+	c.Fallback = newTestTranslator(t, "s0:c1=Accounting\n")
+	const trans = "system_u:object_r:etc_t:Accounting"
+	if got, err := c.RawToTrans(raw); err != nil || got != trans {
+		t.Errorf("RawToTrans: want %q, got %q (err: %v)", trans, got, err)
+	}
+	if got, err := c.TransToRaw(trans); err != nil || got != raw {
+		t.Errorf("TransToRaw: want %q, got %q (err: %v)", raw, got, err)
+	}
+	if got, err := c.TransToRaw("system_u:object_r:etc_t"); err != nil || got != "system_u:object_r:etc_t" {
+		t.Errorf("TransToRaw: unexpected result %q (err: %v)", got, err)
+	}
+}
@@ -0,0 +1,88 @@
+package selinux
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrProcessKindExists is returned by [RegisterProcessKind] for a name
+// which is already in use.
+var ErrProcessKindExists = errors.New("process kind already registered")
+
+type processKindInfo struct {
+	name              string
+	primary, fallback string // Keys in the container contexts file.
+}
+
+var processKinds = struct {
+	sync.RWMutex
+	kinds []processKindInfo // Indexed by ProcessKind.
+}{
+	kinds: []processKindInfo{
+		{},
+		ProcessKindRegular: {"regular", "process", ""},
+		ProcessKindInit:    {"init", "init_process", "process"},
+		ProcessKindKVM:     {"kvm", "kvm_process", "process"},
+	},
+}
+
+// RegisterProcessKind adds a process kind with the given name, for which
+// the process label is the one under the primary key in the container
+// contexts file (such as "sandbox_kvm_process"), or, if there is no such
+// key, under the fallback one, if not empty. The new kind can be used
+// anywhere a ProcessKind is accepted, such as [SetProcessKind] or
+// [NewContainerLabel].
+//
+// The names of the predefined kinds are "regular", "init" and "kvm".
+// If name is already in use, [ErrProcessKindExists] is returned.
+func RegisterProcessKind(name, primary, fallback string) (ProcessKind, error) {
+	if name == "" || primary == "" {
+		return 0, errors.New("process kind name and primary key must not be empty")
+	}
+	processKinds.Lock()
+	defer processKinds.Unlock()
+	for _, info := range processKinds.kinds {
+		if info.name == name {
+			return 0, fmt.Errorf("%w: %s", ErrProcessKindExists, name)
+		}
+	}
+	processKinds.kinds = append(processKinds.kinds, processKindInfo{name, primary, fallback})
+	return ProcessKind(len(processKinds.kinds) - 1), nil
+}
+
+// ProcessKindByName returns the process kind with the given name, which
+// is either one of the predefined kinds, or one added by
+// [RegisterProcessKind].
+func ProcessKindByName(name string) (ProcessKind, bool) {
+	processKinds.RLock()
+	defer processKinds.RUnlock()
+	for k, info := range processKinds.kinds {
+		if k > 0 && info.name == name {
+			return ProcessKind(k), true
+		}
+	}
+	return 0, false
+}
+
+func (k ProcessKind) info() (processKindInfo, bool) {
+	processKinds.RLock()
+	defer processKinds.RUnlock()
+	if k <= 0 || int(k) >= len(processKinds.kinds) {
+		return processKindInfo{}, false
+	}
+	return processKinds.kinds[k], true
+}
+
+// String returns the name of k.
+func (k ProcessKind) String() string {
+	if info, ok := k.info(); ok {
+		return info.name
+	}
+	return fmt.Sprintf("ProcessKind(%d)", int(k))
+}
+
+func (k ProcessKind) keys() (primary, fallback string, ok bool) {
+	info, ok := k.info()
+	return info.primary, info.fallback, ok
+}
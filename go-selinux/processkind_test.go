@@ -0,0 +1,44 @@
+package selinux
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRegisterProcessKind(t *testing.T) {
+	for _, k := range []ProcessKind{ProcessKindRegular, ProcessKindInit, ProcessKindKVM} {
+		if got, ok := ProcessKindByName(k.String()); !ok || got != k {
+			t.Errorf("ProcessKindByName(%q): want %d, got %d, %v", k, k, got, ok)
+		}
+	}
+	if s := ProcessKind(0).String(); s != "ProcessKind(0)" {
+		t.Errorf("unexpected name of ProcessKind(0): %q", s)
+	}
+
+	k, err := RegisterProcessKind("test-sandbox", "sandbox_process", "process")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.String() != "test-sandbox" {
+		t.Errorf("want name %q, got %q", "test-sandbox", k)
+	}
+	if got, ok := ProcessKindByName("test-sandbox"); !ok || got != k {
+		t.Errorf("ProcessKindByName: want %d, got %d, %v", k, got, ok)
+	}
+	if primary, fallback, ok := k.keys(); !ok || primary != "sandbox_process" || fallback != "process" {
+		t.Errorf("unexpected keys: %q, %q, %v", primary, fallback, ok)
+	}
+
+	if _, err := RegisterProcessKind("test-sandbox", "other_process", ""); !errors.Is(err, ErrProcessKindExists) {
+		t.Errorf("want %v, got %v", ErrProcessKindExists, err)
+	}
+	if _, err := RegisterProcessKind("kvm", "other_process", ""); !errors.Is(err, ErrProcessKindExists) {
+		t.Errorf("want %v, got %v", ErrProcessKindExists, err)
+	}
+	if _, err := RegisterProcessKind("test-empty", "", ""); err == nil {
+		t.Error("expected error for empty primary key, got nil")
+	}
+	if _, ok := ProcessKindByName("test-empty"); ok {
+		t.Error("failed registration added a kind")
+	}
+}
@@ -0,0 +1,38 @@
+package selinux
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// withLabel locks the calling goroutine to its OS thread, sets the
+// attribute attr to label using set, and calls fn. Once fn returns (or
+// panics), the previous value of the attribute, as obtained by get before
+// the change, is restored, and the thread is unlocked.
+//
+// If the previous value can not be restored, the thread is left locked,
+// and the error is returned along with the one from fn. The goroutine
+// then stays on the mislabeled thread, which is terminated by the Go
+// runtime once the goroutine exits, rather than reused for others.
+func withLabel(attr string, get func() (string, error), set func(string) error, label string, fn func() error) (err error) {
+	runtime.LockOSThread()
+	prev, err := get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to get %s label: %w", attr, err)
+	}
+	if err := set(label); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to set %s label: %w", attr, err)
+	}
+	defer func() {
+		if rErr := set(prev); rErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to restore %s label %q: %w", attr, prev, rErr))
+			return
+		}
+		runtime.UnlockOSThread()
+	}()
+
+	return fn()
+}
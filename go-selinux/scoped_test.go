@@ -0,0 +1,81 @@
+package selinux
+
+import (
+	"errors"
+	"testing"
+)
+
+// fakeAttr is a label attribute stored in memory.
+type fakeAttr struct {
+	val    string
+	setErr error
+}
+
+func (a *fakeAttr) get() (string, error) {
+	return a.val, nil
+}
+
+func (a *fakeAttr) set(label string) error {
+	if a.setErr != nil && label != "new" {
+		return a.setErr
+	}
+	a.val = label
+	return nil
+}
+
+func TestWithLabel(t *testing.T) {
+	a := &fakeAttr{val: "old"}
+	errFn := errors.New("fn error")
+	err := withLabel("test", a.get, a.set, "new", func() error {
+		if a.val != "new" {
+			t.Errorf("want label %q during fn, got %q", "new", a.val)
+		}
+		return errFn
+	})
+	if !errors.Is(err, errFn) {
+		t.Errorf("want error %v, got %v", errFn, err)
+	}
+	if a.val != "old" {
+		t.Errorf("want restored label %q, got %q", "old", a.val)
+	}
+
+	// Restore on panic.
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected panic")
+			}
+		}()
+		_ = withLabel("test", a.get, a.set, "new", func() error {
+			panic("fn panic")
+		})
+	}()
+	if a.val != "old" {
+		t.Errorf("want restored label %q after panic, got %q", "old", a.val)
+	}
+
+	// If the label can not be set, fn is not called.
+	a.setErr = errors.New("set error")
+	err = withLabel("test", a.get, a.set, "bad", func() error {
+		t.Error("fn called")
+		return nil
+	})
+	if !errors.Is(err, a.setErr) {
+		t.Errorf("want error %v, got %v", a.setErr, err)
+	}
+
+	// Restore failure is reported along with the error from fn. Run in a
+	// separate goroutine, as the thread is left locked.
+	a.setErr = errors.New("restore error")
+	a.val = "old"
+	done := make(chan error)
+	go func() {
+		done <- withLabel("test", a.get, a.set, "new", func() error {
+			return errFn
+		})
+	}()
+	err = <-done
+	if !errors.Is(err, errFn) || !errors.Is(err, a.setErr) {
+		t.Errorf("want both %v and %v, got %v", errFn, a.setErr, err)
+	}
+}
@@ -2,6 +2,9 @@ package selinux
 
 import (
 	"errors"
+	"io"
+	"os"
+	"os/exec"
 )
 
 const (
@@ -36,10 +39,22 @@ var (
 	// ErrVerifierNil is returned when a context verifier function is nil.
 	ErrVerifierNil = errors.New("verifier function is nil")
 
+	// ErrNoSharedLevel is returned by [SharedLevel] if the labels have
+	// no categories in common.
+	ErrNoSharedLevel = errors.New("labels have no categories in common")
+
 	// ErrNotTGLeader is returned by [SetKeyLabel] if the calling thread
 	// is not the thread group leader.
 	ErrNotTGLeader = errors.New("calling thread is not the thread group leader")
 
+	// ErrNotDefined is returned (wrapped in a *[LabelComponentError]) by
+	// [ValidateLabel] for a label component unknown to the policy.
+	ErrNotDefined = errors.New("not defined by the policy")
+	// ErrNotAuthorized is returned (wrapped in a *[LabelComponentError]) by
+	// [ValidateLabel] for a label component which is not allowed along with
+	// the other ones.
+	ErrNotAuthorized = errors.New("not authorized by the policy")
+
 	// CategoryRange allows the upper bound on the category range to be adjusted.
 	//
 	// Deprecated: use [SetCategoryRange] instead.
@@ -49,6 +64,8 @@ var (
 )
 
 // ProcessKind selects which process domain [SetProcessKind] applies to a label.
+// Besides the predefined kinds, custom ones can be added using
+// [RegisterProcessKind].
 type ProcessKind int
 
 const (
@@ -116,12 +133,41 @@ func LfileLabel(fpath string) (string, error) {
 	return lFileLabel(fpath)
 }
 
+// SetFileLabelFd sets the SELinux label for the file descriptor fd,
+// or returns an error. The descriptor may be opened with O_PATH, in
+// which case the file object is referred to by its entry in a safely
+// opened /proc/self/fd (or, for a directory, reopened), so even a symlink
+// opened with O_PATH|O_NOFOLLOW can be relabeled, and, as with
+// [SetFileLabel], no read permission is needed.
+func SetFileLabelFd(fd uintptr, label string) error {
+	return fSetFileLabel(int(fd), label) //#nosec G115 -- ignore "integer overflow conversion uintptr -> int".
+}
+
+// FileLabelFd returns the SELinux label for the file descriptor fd,
+// or returns an error. The descriptor may be opened with O_PATH.
+func FileLabelFd(fd uintptr) (string, error) {
+	return fFileLabel(int(fd)) //#nosec G115 -- ignore "integer overflow conversion uintptr -> int".
+}
+
+// FsetFileLabel sets the SELinux label for the open file f,
+// or returns an error. See [SetFileLabelFd] for details.
+func FsetFileLabel(f *os.File, label string) error {
+	return setFileLabelFile(f, label)
+}
+
+// FfileLabel returns the SELinux label for the open file f,
+// or returns an error. See [FileLabelFd] for details.
+func FfileLabel(f *os.File) (string, error) {
+	return fileLabelFile(f)
+}
+
 // SetFSCreateLabel tells the kernel what label to use for all file system objects
 // created by this task.
 // Set the label to an empty string to return to the default label. Calls to SetFSCreateLabel
 // should be wrapped in runtime.LockOSThread()/runtime.UnlockOSThread() until file system
 // objects created by this task are finished to guarantee another goroutine does not migrate
-// to the current thread before execution is complete.
+// to the current thread before execution is complete. [WithFSCreateLabel] takes
+// care of that.
 func SetFSCreateLabel(label string) error {
 	return setFSCreateLabel(label)
 }
@@ -173,7 +219,7 @@ func CalculateGlbLub(sourceRange, targetRange string) (string, error) {
 // that are executed by the current process thread, or an error. Calls to SetExecLabel
 // should  be wrapped in runtime.LockOSThread()/runtime.UnlockOSThread() until execution
 // of the program is finished to guarantee another goroutine does not migrate to the current
-// thread before execution is complete.
+// thread before execution is complete. [WithExecLabel] takes care of that.
 func SetExecLabel(label string) error {
 	return writeConThreadSelf("attr/exec", label)
 }
@@ -190,7 +236,8 @@ func SetTaskLabel(label string) error {
 // label to the next socket that gets created. Calls to SetSocketLabel
 // should be wrapped in runtime.LockOSThread()/runtime.UnlockOSThread() until
 // the socket is created to guarantee another goroutine does not migrate
-// to the current thread before execution is complete.
+// to the current thread before execution is complete. [WithSocketLabel]
+// takes care of that.
 func SetSocketLabel(label string) error {
 	return writeConThreadSelf("attr/sockcreate", label)
 }
@@ -214,6 +261,8 @@ func PeerLabel(fd uintptr) (string, error) {
 // thread before execution is complete.
 //
 // Only the thread group leader can set key label.
+//
+// See also [WithKeyLabel].
 func SetKeyLabel(label string) error {
 	return setKeyLabel(label)
 }
@@ -223,6 +272,64 @@ func KeyLabel() (string, error) {
 	return keyLabel()
 }
 
+// WithFSCreateLabel calls fn with the file system object creation label
+// of the current thread set to label, as if by [SetFSCreateLabel]. Before
+// fn is called, the calling goroutine is locked to its OS thread. After fn
+// returns, the previous label is restored and the thread is unlocked.
+//
+// If the previous label can not be restored, the goroutine is left locked
+// to the thread, so that the thread is discarded once the goroutine exits,
+// rather than reused with a wrong label. The returned error then includes
+// both the restore error and the one returned by fn.
+func WithFSCreateLabel(label string, fn func() error) error {
+	return withLabel("fscreate", FSCreateLabel, SetFSCreateLabel, label, fn)
+}
+
+// WithExecLabel calls fn with the exec label of the current thread set to
+// label, as if by [SetExecLabel]. See [WithFSCreateLabel] for details.
+func WithExecLabel(label string, fn func() error) error {
+	return withLabel("exec", ExecLabel, SetExecLabel, label, fn)
+}
+
+// WithSocketLabel calls fn with the socket creation label of the current
+// thread set to label, as if by [SetSocketLabel]. See [WithFSCreateLabel]
+// for details.
+func WithSocketLabel(label string, fn func() error) error {
+	return withLabel("sockcreate", SocketLabel, SetSocketLabel, label, fn)
+}
+
+// WithKeyLabel calls fn with the kernel keyring creation label set to
+// label, as if by [SetKeyLabel]. See [WithFSCreateLabel] for details.
+//
+// Note the keyring creation label is shared by all threads of the process,
+// and can only be set by the thread group leader.
+func WithKeyLabel(label string, fn func() error) error {
+	return withLabel("keycreate", KeyLabel, SetKeyLabel, label, fn)
+}
+
+// StartWithExecLabel starts cmd, as exec.Cmd.Start does, with the label
+// of the new program set to label. The exec label is set on the thread
+// that forks the command, and restored once it is started; see
+// [WithExecLabel]. An empty label means the default transition rules
+// of the policy apply.
+//
+// If label is not empty, and the command can not be executed because of
+// a permission error, such as a transition denied by the policy, an
+// *[ExecTransitionError] is returned. Otherwise, the error from
+// exec.Cmd.Start is returned as is.
+func StartWithExecLabel(cmd *exec.Cmd, label string) error {
+	return startWithExecLabel(cmd, label)
+}
+
+// RunWithExecLabel is like [StartWithExecLabel], but also waits for cmd
+// to complete, as exec.Cmd.Run does.
+func RunWithExecLabel(cmd *exec.Cmd, label string) error {
+	if err := startWithExecLabel(cmd, label); err != nil {
+		return err
+	}
+	return cmd.Wait()
+}
+
 // Get returns the Context as a string
 func (c Context) Get() string {
 	return c.get()
@@ -301,7 +408,7 @@ func ROFileLabel() string {
 // KVMContainerLabels returns the default processLabel and mountLabel to be used
 // for kvm containers by the calling process.
 //
-// Deprecated: use [KVMContainerLabel] instead.
+// Deprecated: use [KVMContainerLabel] or [NewContainerLabel] instead.
 func KVMContainerLabels() (string, string) {
 	return kvmContainerLabels()
 }
@@ -317,7 +424,7 @@ func KVMContainerLabel() (string, error) {
 // InitContainerLabels returns the default processLabel and file labels to be
 // used for containers running an init system like systemd by the calling process.
 //
-// Deprecated: use [InitContainerLabel] instead.
+// Deprecated: use [InitContainerLabel] or [NewContainerLabel] instead.
 func InitContainerLabels() (string, string) {
 	return initContainerLabels()
 }
@@ -344,6 +451,58 @@ func SecurityCheckContext(val string) error {
 	return securityCheckContext(val)
 }
 
+// LabelComponentError is returned by [ValidateLabel] for a label which
+// is not valid according to the loaded policy, describing which of its
+// components is wrong.
+type LabelComponentError struct {
+	Label string
+	// Component is "user", "role", "type" or "level".
+	Component string
+	Value     string
+	// Err is [ErrNotDefined] or [ErrNotAuthorized], possibly wrapped
+	// with more details.
+	Err error
+}
+
+func (e *LabelComponentError) Error() string {
+	return "invalid label " + e.Label + ": " + e.Component + " " + e.Value + " " + e.Err.Error()
+}
+
+func (e *LabelComponentError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateLabel checks that label is valid according to the loaded
+// policy, that is, that its user, role and type are defined, the role is
+// authorized for the user and the type, and the level lies within the
+// range of the user. Unlike [SecurityCheckContext], if the label is not
+// valid, it finds out which component is wrong and returns a
+// *[LabelComponentError], unless the label is malformed, in which case
+// [ErrInvalidLabel] is returned. Whether the role and level are authorized
+// for the user is found out using the kernel user interface, with the
+// contexts reachable from the calling process.
+//
+// If the label can not be checked, for example because the caller is
+// not allowed to, no error is returned.
+func ValidateLabel(label string) error {
+	return validateLabel(label)
+}
+
+// SharedLevel returns the narrowest MLS/MCS level that is dominated by
+// the levels of all the given process labels, that is, the lowest of
+// their sensitivities, along with the categories they all have. For
+// labels with a range, its high level (the clearance) is used. Files
+// with this level can be accessed by all of these processes, but not by
+// processes with other categories, so it is suitable for a volume shared
+// by the containers of a pod.
+//
+// If the labels have categories, but none of them in common, the only
+// such level would make the files accessible to every process, and
+// [ErrNoSharedLevel] is returned instead.
+func SharedLevel(processLabels ...string) (string, error) {
+	return sharedLevel(processLabels)
+}
+
 // CopyLevel returns a label with the MLS/MCS level from src label replaced on
 // the dest label.
 func CopyLevel(src, dest string) (string, error) {
@@ -354,11 +513,62 @@ func CopyLevel(src, dest string) (string, error) {
 // If fpath is a directory and recurse is true, then Chcon walks the
 // directory tree setting the label.
 //
-// The fpath itself is guaranteed to be relabeled last.
+// Every directory is guaranteed to be relabeled after its contents,
+// so the fpath itself is relabeled last.
+//
+// When walking the tree, every entry is opened relative to its parent
+// directory and relabeled via the resulting file descriptor, and symlinks
+// are never followed, so a directory concurrently replaced by a symlink
+// can not redirect the relabel to files outside of fpath.
 func Chcon(fpath string, label string, recurse bool) error {
 	return chcon(fpath, label, recurse)
 }
 
+// ChconWithJournal is like [Chcon], but if j is not nil, the label each
+// file object had before being changed is recorded in j, so that it can
+// be restored later using [RestoreJournal].
+func ChconWithJournal(fpath string, label string, recurse bool, j *Journal) error {
+	return chconWithJournal(fpath, label, recurse, j)
+}
+
+// RelabelLevelOptions are the parameters of [RelabelLevel].
+type RelabelLevelOptions struct {
+	// Types, if not nil, is the list of types of the labels to change.
+	// Files with labels of other types are left intact.
+	Types []string
+}
+
+// RelabelLevel changes the MLS/MCS level of the label of fpath to level,
+// keeping the rest of the label. If fpath is a directory, the labels of
+// all its contents are changed the same way, so a tree with files of
+// different types (such as container_file_t and container_ro_file_t)
+// keeps them. Files without a label are skipped. Like [Chcon], it
+// never follows symlinks, and refuses to relabel system directories.
+// A nil opts is the same as a zero RelabelLevelOptions.
+func RelabelLevel(fpath, level string, opts *RelabelLevelOptions) error {
+	return relabelLevel(fpath, level, opts)
+}
+
+// RestoreJournal reads a journal written by a [Journal] from r and sets
+// every recorded file object beneath the directory root back to the label
+// it had before it was relabeled. It can be used both to undo a completed
+// relabel and to roll back one that failed or was interrupted halfway
+// through.
+//
+// The recorded paths are opened relative to root without following
+// symlinks, so that, if the tree was modified since the relabel (for
+// example, by a container it was relabeled for), a directory replaced by
+// a symlink can not redirect the restore elsewhere. Root must thus be a
+// directory the relabeled paths are in, such as the path passed to
+// [ChconWithJournal], and not a symlink.
+//
+// Files which no longer exist, or can no longer be reached from root
+// that way, are skipped. Recorded paths outside of root are reported as
+// errors. Errors do not stop the restore; they are all returned, joined.
+func RestoreJournal(root string, r io.Reader) error {
+	return restoreJournal(root, r)
+}
+
 // DupSecOpt takes an SELinux process label and returns security options that
 // can be used to set the SELinux Type and Level for future container processes.
 func DupSecOpt(src string) ([]string, error) {
@@ -378,6 +588,18 @@ func SEUserByName(username string) (seUser string, level string, err error) {
 	return getSeUserByName(username)
 }
 
+// SEUserByNameForService is like [SEUserByName], but for a login to the
+// given service (such as "sshd" or "crond"). If the
+// /etc/selinux/{SELINUXTYPE}/logins/<username> file exists and has a
+// "service:seuser[:level]" line for the service (or for "*", which
+// matches any service), the SELinux user and level from the first such
+// line are returned; a missing level is taken from the seusers file.
+// Otherwise, it is the same as [SEUserByName], including the handling of
+// %group and __default__ entries.
+func SEUserByNameForService(username, service string) (seUser string, level string, err error) {
+	return getSeUserByNameForService(username, service)
+}
+
 // GetDefaultContextWithLevel gets a single context for the specified SELinux user
 // identity that is reachable from the specified scon context. The context is based
 // on the per-user /etc/selinux/{SELINUXTYPE}/contexts/users/<username> if it exists,
@@ -388,6 +610,67 @@ func GetDefaultContextWithLevel(user, level, scon string) (string, error) {
 	return getDefaultContextWithLevel(user, level, scon)
 }
 
+// GetOrderedContextList returns all the contexts for the specified SELinux
+// user identity and level that are reachable from the specified scon
+// context, in order of preference. The contexts are looked up the same way
+// as by [GetDefaultContextWithLevel], except that all the verified
+// contexts from the first file having any are returned.
+func GetOrderedContextList(user, level, scon string) ([]string, error) {
+	return getOrderedContextList(user, level, scon)
+}
+
+// GetDefaultType returns the default type for the specified role, from the
+// /etc/selinux/{SELINUXTYPE}/contexts/default_type file. If there is none,
+// an error wrapping [ErrContextMissing] is returned.
+func GetDefaultType(role string) (string, error) {
+	return getDefaultType(role)
+}
+
+// LoginContextOptions are the options of [ComputeLoginContext].
+type LoginContextOptions struct {
+	// Level is the requested MLS/MCS range of the session. It is narrowed
+	// down to the range of the SELinux user, using [CalculateGlbLub]. If
+	// empty, the range of the SELinux user is used as is.
+	Level string
+	// Service is the service the login is for, such as "sshd". If set,
+	// the SELinux user and range are resolved using
+	// [SEUserByNameForService].
+	Service string
+	// Role, if set, is the requested role of the session (like newrole -r).
+	// Only the reachable contexts with this role are considered; if there
+	// are none, the default type for the role (see [GetDefaultType]) is
+	// used.
+	Role string
+}
+
+// LoginContext is the result of [ComputeLoginContext].
+type LoginContext struct {
+	// SEUser is the SELinux user the Linux user is mapped to.
+	SEUser string
+	// Level is the MLS/MCS range of the session.
+	Level string
+	// Context is the context to run the session with.
+	Context string
+	// Alternatives are the other contexts reachable for the session,
+	// in order of preference.
+	Alternatives []string
+}
+
+// ComputeLoginContext computes the context of a login session of
+// linuxUser, started by a process running with callerContext, or with
+// the context of the calling thread if empty, the way pam_selinux does.
+// The SELinux user and range are resolved from the seusers file (see
+// [SEUserByName], or [SEUserByNameForService] if opts.Service is set),
+// and the contexts reachable from callerContext are looked up in the
+// per-user, default and failsafe context files (see
+// [GetDefaultContextWithLevel]). The opts may be nil.
+func ComputeLoginContext(linuxUser, callerContext string, opts *LoginContextOptions) (*LoginContext, error) {
+	if opts == nil {
+		opts = &LoginContextOptions{}
+	}
+	return computeLoginContext(linuxUser, callerContext, opts)
+}
+
 // PrivContainerMountLabel returns mount label for privileged containers
 func PrivContainerMountLabel() string {
 	// Make sure label is initialized.
@@ -6,12 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"io/fs"
 	"math/big"
 	"math/rand/v2"
 	"os"
 	"os/user"
 	"path/filepath"
+	"runtime"
 	"slices"
 	"strconv"
 	"strings"
@@ -20,8 +20,6 @@ import (
 	"github.com/cyphar/filepath-securejoin/pathrs-lite"
 	"github.com/cyphar/filepath-securejoin/pathrs-lite/procfs"
 	"golang.org/x/sys/unix"
-
-	"github.com/opencontainers/selinux/pkg/pwalkdir"
 )
 
 const (
@@ -31,6 +29,7 @@ const (
 	selinuxUsersDir  = "contexts/users"
 	defaultContexts  = "contexts/default_contexts"
 	failsafeContext  = "contexts/failsafe_context"
+	defaultType      = "contexts/default_type"
 	selinuxConfig    = selinuxDir + "config"
 	selinuxfsMount   = "/sys/fs/selinux"
 	selinuxTypeTag   = "SELINUXTYPE"
@@ -508,6 +507,261 @@ func lFileLabel(fpath string) (string, error) {
 	return string(label), nil
 }
 
+// isNoLabel reports whether err, as returned by lFileLabel, means the file
+// has no label, or the file system does not support labels.
+func isNoLabel(err error) bool {
+	return errors.Is(err, unix.ENODATA) || errors.Is(err, unix.ENOTSUP)
+}
+
+// openProcSelfFdDir returns an O_PATH handle to /proc/self/fd, opened
+// using [procfs.Handle.OpenSelf], so that whatever is mounted on /proc is
+// not trusted.
+func openProcSelfFdDir() (*os.File, error) {
+	proc, err := procfs.OpenProcRoot()
+	if err != nil {
+		return nil, err
+	}
+	defer proc.Close()
+
+	dir, err := proc.OpenSelf("fd")
+	if err != nil {
+		return nil, fmt.Errorf("open /proc/self/fd handle: %w", err)
+	}
+	return dir, nil
+}
+
+// reopenFd returns a new file for the object the descriptor fd refers to,
+// opened with mode using [pathrs.Reopen], which, unlike a /proc/self/fd
+// path, does not trust whatever is mounted on /proc.
+func reopenFd(fd int, mode int) (*os.File, error) {
+	dup, err := unix.FcntlInt(uintptr(fd), unix.F_DUPFD_CLOEXEC, 0) //#nosec G115 -- fd is non-negative.
+	if err != nil {
+		return nil, err
+	}
+	handle := os.NewFile(uintptr(dup), "fd "+strconv.Itoa(fd))
+	defer handle.Close()
+
+	return pathrs.Reopen(handle, mode)
+}
+
+// procFdDir runs functions on the names of descriptors in /proc/self/fd,
+// on threads of its own whose working directory is the handle returned
+// by openProcSelfFdDir. Path-based syscalls following such a name operate
+// on the very file object the descriptor refers to, without the path
+// being resolved through whatever is mounted on /proc.
+//
+// The handle, and up to twice the runtime.NumCPU() threads, are created
+// on first use, and shared by all the calls until close, so that a tree
+// walk does not create a thread for every file.
+type procFdDir struct {
+	once    sync.Once
+	dir     *os.File
+	err     error
+	reqs    chan procFdReq
+	mu      sync.Mutex
+	threads int
+	active  int            // Calls in progress.
+	ready   sync.WaitGroup // Threads not yet done with dir.
+}
+
+// procFdReq is a call of fn with the name of fd, the result of which is
+// sent to err.
+type procFdReq struct {
+	fd  int
+	fn  func(name string) error
+	err chan<- error
+}
+
+func newProcFdDir() *procFdDir {
+	return &procFdDir{reqs: make(chan procFdReq)}
+}
+
+// do calls fn with the name of fd in /proc/self/fd, relative to the
+// working directory, on one of the threads of d.
+func (d *procFdDir) do(fd int, fn func(name string) error) error {
+	d.once.Do(func() {
+		d.dir, d.err = openProcSelfFdDir()
+	})
+	if d.err != nil {
+		return d.err
+	}
+	d.mu.Lock()
+	d.active++
+	if d.active > d.threads && d.threads < runtime.NumCPU()*2 {
+		// No thread is idle, so start one more.
+		d.threads++
+		d.ready.Add(1)
+		go d.serve()
+	}
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.active--
+		d.mu.Unlock()
+	}()
+
+	errCh := make(chan error, 1)
+	d.reqs <- procFdReq{fd: fd, fn: fn, err: errCh}
+	return <-errCh
+}
+
+// serve runs the requests of d on a thread of its own.
+func (d *procFdDir) serve() {
+	// The thread is never unlocked, so it is terminated along with the
+	// goroutine, and its working directory is never used again.
+	runtime.LockOSThread()
+	err := unix.Unshare(unix.CLONE_FS)
+	if err != nil {
+		err = fmt.Errorf("unshare working directory: %w", err)
+	} else if err = unix.Fchdir(int(d.dir.Fd())); err != nil { //#nosec G115 -- fd fits into int.
+		err = fmt.Errorf("change to /proc/self/fd: %w", err)
+	}
+	d.ready.Done()
+	for req := range d.reqs {
+		if err != nil {
+			req.err <- err
+			continue
+		}
+		req.err <- req.fn(strconv.Itoa(req.fd))
+	}
+}
+
+// close terminates the threads of d, and closes its handle. It must not
+// be called while a call to do is in progress, and d can not be used
+// afterwards.
+func (d *procFdDir) close() {
+	close(d.reqs)
+	d.ready.Wait()
+	if d.dir != nil {
+		d.dir.Close()
+	}
+}
+
+// withXattrFd calls fn with a descriptor for the object the O_PATH
+// descriptor fd refers to, on which, unlike on O_PATH ones, fsetxattr and
+// fgetxattr work, or calls pathFn with the name of fd in /proc/self/fd,
+// using pd (or, if nil, a procFdDir of its own).
+//
+// Only directories are reopened, as reopening needs read permission,
+// which the xattr syscalls do not, and may have side effects for other
+// objects. If a directory can not be reopened because of its
+// permissions, pathFn is used for it as well.
+func withXattrFd(pd *procFdDir, fd int, fn func(fd int) error, pathFn func(name string) error) error {
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return err
+	}
+	if st.Mode&unix.S_IFMT == unix.S_IFDIR {
+		f, err := reopenFd(fd, unix.O_RDONLY|unix.O_DIRECTORY)
+		if err == nil {
+			defer f.Close()
+			return fn(int(f.Fd())) //#nosec G115 -- fd fits into int.
+		}
+		if !errors.Is(err, unix.EACCES) && !errors.Is(err, unix.EPERM) {
+			return err
+		}
+	}
+	if pd == nil {
+		pd = newProcFdDir()
+		defer pd.close()
+	}
+	return pd.do(fd, pathFn)
+}
+
+// fSetFileLabel sets the SELinux label for the file descriptor fd, which
+// may be an O_PATH one, or returns an error.
+func fSetFileLabel(fd int, label string) error {
+	return setFdLabel(nil, fd, label)
+}
+
+// setFdLabel is like fSetFileLabel, but uses pd, if not nil, to relabel
+// O_PATH descriptors (see withXattrFd).
+func setFdLabel(pd *procFdDir, fd int, label string) error {
+	err := fsetxattr(fd, xattrNameSelinux, []byte(label))
+	if err == unix.EBADF { //nolint:errorlint // unix errors are bare
+		// Likely an O_PATH descriptor, which fsetxattr does not support.
+		err = withXattrFd(pd, fd, func(fd int) error {
+			return fsetxattr(fd, xattrNameSelinux, []byte(label))
+		}, func(name string) error {
+			return setxattr(name, xattrNameSelinux, []byte(label))
+		})
+	}
+	if err != nil {
+		return &os.PathError{Op: fmt.Sprintf("fsetxattr(label=%s)", label), Path: "fd " + strconv.Itoa(fd), Err: err}
+	}
+	return nil
+}
+
+// fFileLabel returns the SELinux label for the file descriptor fd, which
+// may be an O_PATH one, or returns an error.
+func fFileLabel(fd int) (string, error) {
+	return fdLabel(nil, fd)
+}
+
+// fdLabel is like fFileLabel, but uses pd, if not nil, to read the label
+// of O_PATH descriptors (see withXattrFd).
+func fdLabel(pd *procFdDir, fd int) (string, error) {
+	label, err := fgetxattr(fd, xattrNameSelinux)
+	if err == unix.EBADF { //nolint:errorlint // unix errors are bare
+		// Likely an O_PATH descriptor, which fgetxattr does not support.
+		err = withXattrFd(pd, fd, func(fd int) (err error) {
+			label, err = fgetxattr(fd, xattrNameSelinux)
+			return err
+		}, func(name string) (err error) {
+			label, err = getxattr(name, xattrNameSelinux)
+			return err
+		})
+	}
+	if err != nil {
+		return "", &os.PathError{Op: "fgetxattr", Path: "fd " + strconv.Itoa(fd), Err: err}
+	}
+	// Trim the NUL byte at the end of the byte buffer, if present.
+	if len(label) > 0 && label[len(label)-1] == '\x00' {
+		label = label[:len(label)-1]
+	}
+	return string(label), nil
+}
+
+// withFileFd calls fn with the file descriptor of f. Unlike f.Fd, it does
+// not put the descriptor into blocking mode. If fn returns an *os.PathError,
+// its path is set to the name of f.
+func withFileFd(f *os.File, fn func(fd int) error) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var fnErr error
+	if err := rc.Control(func(fd uintptr) {
+		fnErr = fn(int(fd)) //#nosec G115 -- ignore "integer overflow conversion uintptr -> int".
+	}); err != nil {
+		return err
+	}
+	var pErr *os.PathError
+	if errors.As(fnErr, &pErr) {
+		pErr.Path = f.Name()
+	}
+	return fnErr
+}
+
+// setFileLabelFile sets the SELinux label for the open file f,
+// or returns an error.
+func setFileLabelFile(f *os.File, label string) error {
+	return withFileFd(f, func(fd int) error {
+		return fSetFileLabel(fd, label)
+	})
+}
+
+// fileLabelFile returns the SELinux label for the open file f,
+// or returns an error.
+func fileLabelFile(f *os.File) (string, error) {
+	var label string
+	err := withFileFd(f, func(fd int) (err error) {
+		label, err = fFileLabel(fd)
+		return err
+	})
+	return label, err
+}
+
 func setFSCreateLabel(label string) error {
 	return writeConThreadSelf("attr/fscreate", label)
 }
@@ -658,6 +912,21 @@ func bitsetToStr(c *big.Int) string {
 	return str
 }
 
+// dominates reports whether l dominates l2, that is, whether its
+// sensitivity is at least that of l2, and it has all the categories of l2.
+func (l *level) dominates(l2 *level) bool {
+	if l.sens < l2.sens {
+		return false
+	}
+	if l2.cats == nil || l2.cats.BitLen() == 0 {
+		return true
+	}
+	if l.cats == nil {
+		return false
+	}
+	return new(big.Int).AndNot(l2.cats, l.cats).BitLen() == 0
+}
+
 func (l *level) equal(l2 *level) bool {
 	if l2 == nil || l == nil {
 		return l == l2
@@ -690,6 +959,79 @@ func (m mlsRange) String() string {
 	return low + "-" + high
 }
 
+// normalizeLevel returns the canonical form of the MLS/MCS level or range.
+func normalizeLevel(level string) (string, error) {
+	r, err := rangeStrToMLSRange(level)
+	if err != nil {
+		return "", err
+	}
+	return r.String(), nil
+}
+
+// policyFileContexts returns the paths of the file_contexts files of the
+// loaded policy, in the order they are to be read, and the paths of its
+// path equivalence files, in the order they are to be applied.
+func policyFileContexts() (fcs, subs []string) {
+	fc := filepath.Join(policyRoot(), "contexts", "files", "file_contexts")
+	return []string{fc, fc + ".homedirs", fc + ".local"}, []string{fc + ".subs", fc + ".subs_dist"}
+}
+
+// levelHasCategory reports whether the MLS/MCS level or range mls has
+// the category cat.
+func levelHasCategory(mls string, cat uint) bool {
+	r, err := rangeStrToMLSRange(mls)
+	if err != nil {
+		return false
+	}
+	for _, l := range []*level{r.low, r.high} {
+		if l.cats != nil && l.cats.Bit(int(cat)) == 1 { //#nosec G115 -- cat is a category number.
+			return true
+		}
+	}
+	return false
+}
+
+// sharedLevel returns the highest level dominated by the high levels
+// (clearances) of all labels: the lowest of their sensitivities, with only
+// the categories present in every one of them. The high level is what
+// file access checks compare against for MLS/MCS, so a process with a
+// range such as s0-s0:c0.c1023 can access files with any categories.
+func sharedLevel(labels []string) (string, error) {
+	if len(labels) == 0 {
+		return "", fmt.Errorf("no labels to compute a shared level for: %w", ErrInvalidLabel)
+	}
+	var (
+		shared  *level
+		anyCats bool
+	)
+	for _, label := range labels {
+		con, err := newContext(label)
+		if err != nil {
+			return "", fmt.Errorf("bad label %q: %w", label, err)
+		}
+		r, err := rangeStrToMLSRange(con["level"])
+		if err != nil {
+			return "", fmt.Errorf("bad label %q: %w", label, err)
+		}
+		cats := new(big.Int)
+		if r.high.cats != nil {
+			cats.Set(r.high.cats)
+		}
+		anyCats = anyCats || cats.BitLen() > 0
+		if shared == nil {
+			shared = &level{sens: r.high.sens, cats: cats}
+			continue
+		}
+		shared.sens = min(shared.sens, r.high.sens)
+		shared.cats.And(shared.cats, cats)
+	}
+	if anyCats && shared.cats.BitLen() == 0 {
+		return "", ErrNoSharedLevel
+	}
+
+	return mlsRange{low: shared, high: shared}.String(), nil
+}
+
 // calculateGlbLub computes the glb (greatest lower bound) and lub (least upper bound)
 // of a source and target range.
 // The glblub is calculated as the greater of the low sensitivities and
@@ -1003,12 +1345,11 @@ func label(key string) string {
 // kvmContainerLabels returns the default processLabel and mountLabel to be used
 // for kvm containers by the calling process.
 func kvmContainerLabels() (string, string) {
-	processLabel := label("kvm_process")
-	if processLabel == "" {
-		processLabel = label("process")
+	c, err := newContainerLabel(ProcessKindKVM, &ContainerLabelOptions{})
+	if err != nil {
+		return "", label("file")
 	}
-
-	return addMcs(processLabel, label("file"))
+	return c.ProcessLabel, c.MountLabel
 }
 
 func kvmContainerLabel() (string, error) {
@@ -1023,12 +1364,11 @@ func kvmContainerLabel() (string, error) {
 // initContainerLabels returns the default processLabel and file labels to be
 // used for containers running an init system like systemd by the calling process.
 func initContainerLabels() (string, string) {
-	processLabel := label("init_process")
-	if processLabel == "" {
-		processLabel = label("process")
+	c, err := newContainerLabel(ProcessKindInit, &ContainerLabelOptions{})
+	if err != nil {
+		return "", label("file")
 	}
-
-	return addMcs(processLabel, label("file"))
+	return c.ProcessLabel, c.MountLabel
 }
 
 func initContainerLabel() (string, error) {
@@ -1048,19 +1388,106 @@ func containerLabels() (processLabel string, fileLabel string) {
 		return "", ""
 	}
 
-	processLabel = label("process")
 	fileLabel = label("file")
-	readOnlyFileLabel = label("ro_file")
+	if label("process") == "" || fileLabel == "" {
+		readOnlyFileLabel = label("ro_file")
+		return "", fileLabel
+	}
 
-	if processLabel == "" || fileLabel == "" {
+	c, err := newContainerLabel(ProcessKindRegular, &ContainerLabelOptions{})
+	if err != nil {
 		return "", fileLabel
 	}
+	readOnlyFileLabel = c.ROFileLabel
+	return c.ProcessLabel, c.MountLabel
+}
+
+// newContainerLabel allocates the labels for a container of the given kind,
+// see [NewContainerLabel].
+func newContainerLabel(kind ProcessKind, opts *ContainerLabelOptions) (*ContainerLabel, error) {
+	primary, fallback, ok := kind.keys()
+	if !ok {
+		return nil, fmt.Errorf("invalid ProcessKind %d", kind)
+	}
+	processLabel := label(primary)
+	if processLabel == "" && fallback != "" {
+		processLabel = label(fallback)
+	}
+	c := &ContainerLabel{
+		MountLabel:  label("file"),
+		ROFileLabel: label("ro_file"),
+	}
+	if c.ROFileLabel == "" {
+		c.ROFileLabel = c.MountLabel
+	}
+	if processLabel == "" {
+		return c, nil
+	}
 
-	if readOnlyFileLabel == "" {
-		readOnlyFileLabel = fileLabel
+	pcon, err := newContext(processLabel)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s label %s: %w", primary, processLabel, err)
+	}
+	mcon, err := newContext(c.MountLabel)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file label %s: %w", c.MountLabel, err)
+	}
+	if opts.User != "" {
+		pcon["user"] = opts.User
+		mcon["user"] = opts.User
+	}
+	if opts.Role != "" {
+		pcon["role"] = opts.Role
+	}
+	if opts.Type != "" {
+		pcon["type"] = opts.Type
+	}
+	if opts.FileType != "" {
+		mcon["type"] = opts.FileType
+	}
+	if *opts != (ContainerLabelOptions{}) {
+		// Check the requested labels before reserving anything, so that
+		// a wrong option is reported as such, rather than failing later.
+		if err = validateContainerLabel(pcon, opts.Level); err != nil {
+			return nil, err
+		}
+		if c.MountLabel != "" {
+			if err = validateContainerLabel(mcon, opts.Level); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	level := opts.Level
+	owned := false
+	if level != "" {
+		// A user-specified level may already be reserved, e.g. when
+		// containers in a pod use the same one; it is then shared rather
+		// than owned.
+		err = mcsAdd(level)
+		if err != nil && !errors.Is(err, ErrMCSAlreadyExists) {
+			return nil, err
+		}
+		owned = err == nil && strings.Contains(level, ":c")
+	} else if pcon["level"] != "" {
+		level = uniqMcs(CategoryRange)
+		owned = true
+	}
+	if level != "" {
+		pcon["level"] = level
+		if c.MountLabel != "" {
+			mcon["level"] = level
+		}
 	}
 
-	return addMcs(processLabel, fileLabel)
+	c.ProcessLabel = pcon.get()
+	if c.MountLabel != "" {
+		c.MountLabel = mcon.get()
+	}
+	if owned {
+		c.reserved = c.ProcessLabel
+	}
+	return c, nil
 }
 
 func addMcsProc(processLabel string) (string, string, error) {
@@ -1077,21 +1504,146 @@ func addMcsProc(processLabel string) (string, string, error) {
 	return processLabel, mcs, nil
 }
 
-func addMcs(processLabel, fileLabel string) (string, string) {
-	processLabel, mcs, _ := addMcsProc(processLabel)
-	if mcs != "" {
-		scon, _ := NewContext(fileLabel)
-		scon["level"] = mcs
-		fileLabel = scon.Get()
-	}
-	return processLabel, fileLabel
-}
-
 // securityCheckContext validates that the SELinux label is understood by the kernel
 func securityCheckContext(val string) error {
 	return os.WriteFile(filepath.Join(getSelinuxMountPoint(), "context"), []byte(val), 0)
 }
 
+// validateContainerLabel validates con, with its level replaced by level,
+// if not empty.
+func validateContainerLabel(con Context, level string) error {
+	if level != "" {
+		con = Context{"user": con["user"], "role": con["role"], "type": con["type"], "level": level}
+	}
+	return validateLabel(con.get())
+}
+
+// validateLabel checks val against the loaded policy, see [ValidateLabel].
+func validateLabel(val string) error {
+	ref := label("file")
+	if ref == "" {
+		ref, _ = fileLabel("/")
+	}
+	return checkLabelComponents(val, ref, securityCheckContext, func(user string) ([]string, error) {
+		scon, err := CurrentLabel()
+		if err != nil {
+			return nil, err
+		}
+		return computeUserContexts(scon, user)
+	})
+}
+
+// computeUserContexts returns the contexts of the SELinux user reachable
+// from scon, as computed by the kernel user interface (the equivalent of
+// security_compute_user(3)).
+func computeUserContexts(scon, user string) ([]string, error) {
+	out, err := readWriteCon(filepath.Join(getSelinuxMountPoint(), "user"), scon+" "+user)
+	if err != nil {
+		return nil, err
+	}
+	return parseUserContexts(out)
+}
+
+// parseUserContexts parses the output of the kernel user interface: the
+// number of contexts, followed by the contexts, all NUL-separated.
+func parseUserContexts(out string) ([]string, error) {
+	fields := strings.Split(strings.TrimRight(out, "\x00"), "\x00")
+	n, err := strconv.Atoi(fields[0])
+	if err != nil || n != len(fields)-1 {
+		return nil, fmt.Errorf("unexpected user contexts %q: %w", out, ErrInvalidLabel)
+	}
+	return fields[1:], nil
+}
+
+// checkLabelComponents checks val using check and, if it is not valid,
+// finds out which of its components is wrong. Whether the user, the type
+// and the level are defined is found out using check with the object_r
+// role and the other components of ref, and whether the role and level
+// are authorized for the user, using the contexts of the user returned
+// by userContexts.
+//
+// Only EINVAL from check means val is not valid. Any other error, such
+// as EACCES when the caller is not allowed to check contexts, means the
+// check can not be done, so val is assumed to be valid.
+func checkLabelComponents(val, ref string, check func(string) error, userContexts func(user string) ([]string, error)) error {
+	err := check(val)
+	if err == nil || !errors.Is(err, unix.EINVAL) {
+		return nil
+	}
+	con, cErr := newContext(val)
+	if cErr != nil || val == "" {
+		return ErrInvalidLabel
+	}
+	rcon, rErr := newContext(ref)
+	if rErr != nil || ref == "" {
+		return fmt.Errorf("invalid label %s: %w", val, err)
+	}
+
+	// For contexts with the object_r role, only the existence of the user
+	// and the type, and the validity of the level are checked.
+	const objectRole = "object_r"
+	defined := func(user, typ, level string) bool {
+		return !errors.Is(check(Context{"user": user, "role": objectRole, "type": typ, "level": level}.get()), unix.EINVAL)
+	}
+	componentErr := func(component string, reason error) error {
+		return &LabelComponentError{Label: val, Component: component, Value: con[component], Err: reason}
+	}
+	switch {
+	case con["level"] != "" && !defined(rcon["user"], rcon["type"], con["level"]):
+		return componentErr("level", ErrNotDefined)
+	case !defined(con["user"], rcon["type"], rcon["level"]):
+		return componentErr("user", ErrNotDefined)
+	case !defined(rcon["user"], con["type"], rcon["level"]):
+		return componentErr("type", ErrNotDefined)
+	}
+
+	// All the components are defined, so either the role is not
+	// authorized for the user or the type, or the level is not within
+	// the range of the user.
+	conns, uErr := userContexts(con["user"])
+	if uErr != nil || len(conns) == 0 {
+		return fmt.Errorf("invalid label %s: %w", val, err)
+	}
+	var roleOK, levelOK bool
+	for _, conn := range conns {
+		ucon, err := newContext(conn)
+		if err != nil {
+			continue
+		}
+		if ucon["role"] != con["role"] {
+			continue
+		}
+		roleOK = true
+		if con["level"] == "" || levelWithin(con["level"], ucon["level"]) {
+			levelOK = true
+		}
+	}
+	switch {
+	case con["role"] != objectRole && !roleOK:
+		return componentErr("role", fmt.Errorf("%w for user %s", ErrNotAuthorized, con["user"]))
+	case con["role"] != objectRole && !levelOK:
+		return componentErr("level", fmt.Errorf("%w for user %s", ErrNotAuthorized, con["user"]))
+	case con["role"] != objectRole:
+		return componentErr("role", fmt.Errorf("%w for type %s", ErrNotAuthorized, con["type"]))
+	}
+	return fmt.Errorf("invalid label %s: %w", val, err)
+}
+
+// levelWithin reports whether the MLS/MCS level or range mls lies within
+// the range rng, that is, whether its low level dominates the low level
+// of rng, and its high level is dominated by the high level of rng.
+func levelWithin(mls, rng string) bool {
+	m, err := rangeStrToMLSRange(mls)
+	if err != nil {
+		return false
+	}
+	r, err := rangeStrToMLSRange(rng)
+	if err != nil {
+		return false
+	}
+	return m.low.dominates(r.low) && r.high.dominates(m.high)
+}
+
 // copyLevel returns a label with the MLS/MCS level from src label replaced on
 // the dest label.
 func copyLevel(src, dest string) (string, error) {
@@ -1122,6 +1674,12 @@ func copyLevel(src, dest string) (string, error) {
 // If fpath is a directory and recurse is true, then chcon walks the
 // directory tree setting the label.
 func chcon(fpath string, label string, recurse bool) error {
+	return chconWithJournal(fpath, label, recurse, nil)
+}
+
+// chconWithJournal is like chcon, but if j is not nil, the previous label
+// of every file object is recorded in j before it is changed.
+func chconWithJournal(fpath string, label string, recurse bool, j *Journal) error {
 	if fpath == "" {
 		return ErrEmptyPath
 	}
@@ -1129,6 +1687,43 @@ func chcon(fpath string, label string, recurse bool) error {
 		return nil
 	}
 
+	fpath, err := checkRelabelPath(fpath)
+	if err != nil {
+		return err
+	}
+
+	if !recurse {
+		if err = journalLabel(j, fpath, label, func() (string, error) {
+			return lFileLabel(fpath)
+		}); err != nil {
+			return err
+		}
+		err = lSetFileLabel(fpath, label)
+		if err != nil {
+			// Check if file doesn't exist, must have been removed
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			// Check if current label is correct on disk
+			flabel, nerr := lFileLabel(fpath)
+			if nerr == nil && flabel == label {
+				return nil
+			}
+			// Check if file doesn't exist, must have been removed
+			if errors.Is(nerr, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		return nil
+	}
+
+	return rchcon(fpath, label, j)
+}
+
+// checkRelabelPath returns fpath without a trailing slash, or an error if
+// fpath is a system directory which must never be relabeled as a whole.
+func checkRelabelPath(fpath string) (string, error) {
 	excludePaths := map[string]bool{
 		"/":           true,
 		"/bin":        true,
@@ -1170,55 +1765,185 @@ func chcon(fpath string, label string, recurse bool) error {
 		fpath = strings.TrimSuffix(fpath, "/")
 	}
 	if excludePaths[fpath] {
-		return fmt.Errorf("SELinux relabeling of %s is not allowed", fpath)
-	}
-
-	if !recurse {
-		err := lSetFileLabel(fpath, label)
-		if err != nil {
-			// Check if file doesn't exist, must have been removed
-			if errors.Is(err, os.ErrNotExist) {
-				return nil
-			}
-			// Check if current label is correct on disk
-			flabel, nerr := lFileLabel(fpath)
-			if nerr == nil && flabel == label {
-				return nil
-			}
-			// Check if file doesn't exist, must have been removed
-			if errors.Is(nerr, os.ErrNotExist) {
-				return nil
-			}
-			return err
-		}
-		return nil
+		return "", fmt.Errorf("SELinux relabeling of %s is not allowed", fpath)
 	}
 
-	return rchcon(fpath, label)
+	return fpath, nil
 }
 
-func rchcon(fpath, label string) error { //revive:disable:cognitive-complexity
+func rchcon(fpath, label string, j *Journal) error { //revive:disable:cognitive-complexity
 	fastMode := false
 	// If the current label matches the new label, assume
 	// other labels are correct.
 	if cLabel, err := lFileLabel(fpath); err == nil && cLabel == label {
 		fastMode = true
 	}
-	return pwalkdir.Walk(fpath, func(p string, _ fs.DirEntry, _ error) error {
+	// Walk the tree using file descriptors, so that a directory replaced
+	// by a symlink during the walk can not redirect the relabel outside.
+	// Every directory is relabeled after its contents, so that an
+	// interrupted relabel never leaves a directory with the new label
+	// while some of its contents still have the old one, which fastMode
+	// relies upon.
+	pd := newProcFdDir()
+	defer pd.close()
+	return fdWalk(fpath, func(p string, fd int, _ bool) error {
 		if fastMode {
-			if cLabel, err := lFileLabel(p); err == nil && cLabel == label {
+			if cLabel, err := fdLabel(pd, fd); err == nil && cLabel == label {
 				return nil
 			}
 		}
-		err := lSetFileLabel(p, label)
+		if err := journalLabel(j, p, label, func() (string, error) {
+			return fdLabel(pd, fd)
+		}); err != nil {
+			return err
+		}
+		err := setFdLabel(pd, fd, label)
 		// Walk a file tree can race with removal, so ignore ENOENT.
 		if errors.Is(err, os.ErrNotExist) {
 			return nil
 		}
+		var pErr *os.PathError
+		if errors.As(err, &pErr) {
+			pErr.Path = p
+		}
 		return err
 	})
 }
 
+// relabelLevel sets the MLS/MCS level of the labels of fpath and, if it is
+// a directory, of all its contents, to level.
+func relabelLevel(fpath, level string, opts *RelabelLevelOptions) error {
+	if fpath == "" {
+		return ErrEmptyPath
+	}
+	r, err := rangeStrToMLSRange(level)
+	if err != nil {
+		return fmt.Errorf("invalid level %q: %w", level, err)
+	}
+	level = r.String()
+	fpath, err = checkRelabelPath(fpath)
+	if err != nil {
+		return err
+	}
+	var types map[string]bool
+	if opts != nil && opts.Types != nil {
+		types = make(map[string]bool, len(opts.Types))
+		for _, t := range opts.Types {
+			types[t] = true
+		}
+	}
+
+	pd := newProcFdDir()
+	defer pd.close()
+	return fdWalk(fpath, func(p string, fd int, _ bool) error {
+		cLabel, wErr := fdLabel(pd, fd)
+		if wErr == nil {
+			con, cErr := newContext(cLabel)
+			if cErr != nil || cLabel == "" || (types != nil && !types[con["type"]]) {
+				// Leave files with no, unparsable, or excluded labels alone.
+				return nil
+			}
+			con["level"] = level
+			if label := con.get(); label != cLabel {
+				wErr = setFdLabel(pd, fd, label)
+			}
+		}
+		// Walk a file tree can race with removal, so ignore ENOENT.
+		if wErr == nil || errors.Is(wErr, os.ErrNotExist) || isNoLabel(wErr) {
+			return nil
+		}
+		var pErr *os.PathError
+		if errors.As(wErr, &pErr) {
+			pErr.Path = p
+		}
+		return wErr
+	})
+}
+
+// journalLabel records the current label of fpath, as returned by
+// getLabel, in j, unless j is nil or fpath already has the label. Files
+// that do not exist or have no label are not recorded, as there is
+// nothing to restore for them. Any other error getting the label is
+// returned, so that the file is not relabeled without its original
+// label being recorded.
+func journalLabel(j *Journal, fpath, label string, getLabel func() (string, error)) error {
+	if j == nil {
+		return nil
+	}
+	cLabel, err := getLabel()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) || isNoLabel(err) {
+			return nil
+		}
+		var pErr *os.PathError
+		if errors.As(err, &pErr) {
+			pErr.Path = fpath
+		}
+		return err
+	}
+	if cLabel == label {
+		return nil
+	}
+	return j.Record(fpath, cLabel)
+}
+
+// restoreJournal reads the journal from r and puts back the labels it
+// recorded for the paths beneath root. Entries are replayed newest to
+// oldest, so a path that was relabeled several times ends up with its
+// original label.
+//
+// Every path is opened relative to root, one component at a time, never
+// following symlinks, so a directory replaced by a symlink since the
+// relabel can not redirect the restore outside of root.
+func restoreJournal(root string, r io.Reader) error {
+	if root == "" {
+		return ErrEmptyPath
+	}
+	entries, err := readJournal(r)
+	if err != nil {
+		return err
+	}
+	rootFd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: root, Err: err}
+	}
+	defer unix.Close(rootFd)
+
+	pd := newProcFdDir()
+	defer pd.close()
+
+	root = filepath.Clean(root)
+	var errs []error
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		rel, err := filepath.Rel(root, filepath.Clean(e.path))
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			errs = append(errs, fmt.Errorf("can not restore label of %s: not beneath %s", e.path, root))
+			continue
+		}
+		fd, err := openPathBeneath(rootFd, rel)
+		if err != nil {
+			// The file may have been removed since, or it, or one of
+			// its parent directories, replaced by a symlink. There is
+			// nothing to restore then.
+			if !errors.Is(err, unix.ENOENT) && !errors.Is(err, unix.ENOTDIR) && !errors.Is(err, unix.ELOOP) {
+				errs = append(errs, &os.PathError{Op: "openat", Path: e.path, Err: err})
+			}
+			continue
+		}
+		err = setFdLabel(pd, fd, e.label)
+		unix.Close(fd)
+		if err != nil {
+			var pErr *os.PathError
+			if errors.As(err, &pErr) {
+				pErr.Path = e.path
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // dupSecOpt takes an SELinux process label and returns security options that
 // can be used to set the SELinux Type and Level for future container processes.
 func dupSecOpt(src string) ([]string, error) {
@@ -1352,61 +2077,83 @@ func getSeUserByName(username string) (string, string, error) {
 	return seUser, level, nil
 }
 
-// findUserInContext scans the reader for a valid SELinux context
-// match that is verified with the verifier. Invalid contexts are
-// skipped. It returns a matched context or an empty string if no
-// match is found. If a scanner error occurs, it is returned.
-func findUserInContext(context Context, r io.Reader, verifier func(string) error) (string, error) {
-	fromRole := context["role"]
-	fromType := context["type"]
+// getServiceUserFromReader returns the SELinux user and level for service
+// from a logins/<user> file read from r, the lines of which are formatted
+// as service:seuser[:level], where service may be "*" to match any
+// service. As in libselinux, a line for service wins wherever it appears,
+// and the first "*" line is only used if there is none; ok is false if
+// neither is found.
+func getServiceUserFromReader(service string, r io.Reader) (seUser, level string, ok bool, err error) {
+	var anyUser, anyLevel string
 	scanner := bufio.NewScanner(r)
-
 	for scanner.Scan() {
-		fromConns := strings.Fields(scanner.Text())
-		if len(fromConns) == 0 {
-			// Skip blank lines
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
 			continue
 		}
-
-		line := fromConns[0]
-
-		if line[0] == ';' || line[0] == '#' {
-			// Skip comments
+		svc, rest, found := strings.Cut(line, ":")
+		if !found || (svc != service && svc != "*") {
 			continue
 		}
-
-		// user context files contexts are formatted as
-		// role_r:type_t:s0 where the user is missing.
-		lineArr := strings.SplitN(line, ":", 4)
-		// skip context with typo, or role and type do not match
-		if len(lineArr) != 3 ||
-			lineArr[0] != fromRole ||
-			lineArr[1] != fromType {
+		u, l, _ := strings.Cut(rest, ":")
+		if u == "" {
 			continue
 		}
+		if svc == service {
+			return u, l, true, nil
+		}
+		if anyUser == "" {
+			anyUser, anyLevel = u, l
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return "", "", false, fmt.Errorf("failed to read logins file: %w", err)
+	}
+	return anyUser, anyLevel, anyUser != "", nil
+}
 
-		for _, cc := range fromConns[1:] {
-			toConns := strings.SplitN(cc, ":", 4)
-			if len(toConns) != 3 {
-				continue
-			}
-
-			context["role"] = toConns[0]
-			context["type"] = toConns[1]
-
-			outConn := context.get()
-			if err := verifier(outConn); err != nil {
-				continue
-			}
-
-			return outConn, nil
+// getSeUserByNameForService is like getSeUserByName, but uses the mapping
+// for service from the logins/<username> file, if any. If the mapping has
+// no level, the one from the seusers file is used.
+func getSeUserByNameForService(username, service string) (string, string, error) {
+	if service == "" || username == "" || strings.ContainsRune(username, '/') {
+		return getSeUserByName(username)
+	}
+	f, err := os.Open(filepath.Join(policyRoot(), "logins", username))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return getSeUserByName(username)
 		}
+		return "", "", fmt.Errorf("failed to open logins file: %w", err)
 	}
-	if err := scanner.Err(); err != nil {
-		return "", fmt.Errorf("failed to scan for context: %w", err)
+	defer f.Close()
+
+	seUser, level, ok, err := getServiceUserFromReader(service, f)
+	if err != nil {
+		return "", "", err
+	}
+	if !ok {
+		return getSeUserByName(username)
+	}
+	if level == "" {
+		// The level is optional; ignore errors, as the user may have no
+		// seusers entry.
+		_, level, _ = getSeUserByName(username)
 	}
+	return seUser, level, nil
+}
 
-	return "", nil
+// findUserInContext scans the reader for a valid SELinux context
+// match that is verified with the verifier. Invalid contexts are
+// skipped. It returns a matched context or an empty string if no
+// match is found. If a scanner error occurs, it is returned.
+func findUserInContext(context Context, r io.Reader, verifier func(string) error) (string, error) {
+	var conn string
+	err := scanUserContexts(context, r, verifier, func(c string) bool {
+		conn = c
+		return false
+	})
+	return conn, err
 }
 
 // getFailsafeContext returns the context in the failsafe_context file:
@@ -1516,16 +2263,236 @@ func getDefaultContextWithLevel(user, level, scon string) (string, error) {
 	return getDefaultContextFromReaders(&c)
 }
 
-func (k ProcessKind) keys() (primary, fallback string, ok bool) {
-	switch k {
-	case ProcessKindRegular:
-		return "process", "", true
-	case ProcessKindInit:
-		return "init_process", "process", true
-	case ProcessKindKVM:
-		return "kvm_process", "process", true
+// findUserContexts is like findUserInContext, but returns all the verified
+// contexts reachable from context, in the order they are found, without
+// duplicates.
+func findUserContexts(context Context, r io.Reader, verifier func(string) error) ([]string, error) {
+	var found []string
+	seen := make(map[string]bool)
+	err := scanUserContexts(context, r, verifier, func(c string) bool {
+		if !seen[c] {
+			seen[c] = true
+			found = append(found, c)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// scanUserContexts scans r, a per-user or default context file, for the
+// contexts reachable from the role and type of context, with its user and
+// level, and calls found for each one verified with the verifier, in the
+// order they are listed, as long as found returns true. The context is
+// not modified.
+func scanUserContexts(context Context, r io.Reader, verifier func(string) error, found func(string) bool) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fromConns := strings.Fields(scanner.Text())
+		if len(fromConns) == 0 || fromConns[0][0] == ';' || fromConns[0][0] == '#' {
+			// Skip blank lines and comments
+			continue
+		}
+
+		// user context files contexts are formatted as
+		// role_r:type_t:s0 where the user is missing.
+		lineArr := strings.SplitN(fromConns[0], ":", 4)
+		// skip context with typo, or role and type do not match
+		if len(lineArr) != 3 ||
+			lineArr[0] != context["role"] ||
+			lineArr[1] != context["type"] {
+			continue
+		}
+
+		for _, cc := range fromConns[1:] {
+			toConns := strings.SplitN(cc, ":", 4)
+			if len(toConns) != 3 {
+				continue
+			}
+
+			outConn := Context{"user": context["user"], "role": toConns[0], "type": toConns[1], "level": context["level"]}.get()
+			if err := verifier(outConn); err != nil {
+				continue
+			}
+			if !found(outConn) {
+				return nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to scan for context: %w", err)
+	}
+	return nil
+}
+
+// getOrderedContextsFromReaders is like getDefaultContextFromReaders, but
+// returns all the contexts reachable from the per-user context file, or,
+// if there are none, from the default context file, or the failsafe
+// context. A missing per-user context file is not an error.
+func getOrderedContextsFromReaders(c *defaultSECtx) ([]string, error) {
+	if c.verifier == nil {
+		return nil, ErrVerifierNil
+	}
+
+	context, err := newContext(c.scon)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create label for %s: %w", c.scon, err)
+	}
+	context["user"] = c.user
+	context["level"] = c.level
+
+	var conns []string
+	userRdr, err := c.openUserRdr()
+	switch {
+	case err == nil:
+		conns, err = findUserContexts(context, userRdr, c.verifier)
+		userRdr.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %q's user context file: %w", c.user, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("failed to open user context file: %w", err)
+	}
+	if len(conns) > 0 {
+		return conns, nil
+	}
+
+	defaultRdr, err := c.openDefaultRdr()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open default context file: %w", err)
+	}
+	defer defaultRdr.Close()
+
+	conns, err = findUserContexts(context, defaultRdr, c.verifier)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read default user context file: %w", err)
+	}
+	if len(conns) > 0 {
+		return conns, nil
+	}
+
+	failsafeRdr, err := c.openFailsafeRdr()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open failsafe context file: %w", err)
+	}
+	defer failsafeRdr.Close()
+
+	conn, err := getFailsafeContext(context, failsafeRdr, c.verifier)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read failsafe_context: %w", err)
+	}
+	if conn != "" {
+		return []string{conn}, nil
+	}
+
+	return nil, fmt.Errorf("context %q not found: %w", c.scon, ErrContextMissing)
+}
+
+func getOrderedContextList(user, level, scon string) ([]string, error) {
+	c := defaultSECtx{
+		user:            user,
+		level:           level,
+		scon:            scon,
+		openUserRdr:     createOpener(filepath.Join(policyRoot(), selinuxUsersDir, user)),
+		openDefaultRdr:  createOpener(filepath.Join(policyRoot(), defaultContexts)),
+		openFailsafeRdr: createOpener(filepath.Join(policyRoot(), failsafeContext)),
+		verifier:        securityCheckContext,
+	}
+
+	return getOrderedContextsFromReaders(&c)
+}
+
+// getDefaultTypeFromReader returns the type for role in the default_type
+// file read from r, the lines of which are formatted as role_r:type_t.
+func getDefaultTypeFromReader(role string, r io.Reader) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == ';' || line[0] == '#' {
+			// Skip blank lines and comments
+			continue
+		}
+		if lineRole, typ, ok := strings.Cut(line, ":"); ok && lineRole == role && typ != "" {
+			return typ, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to scan for default type: %w", err)
+	}
+	return "", fmt.Errorf("no default type for role %s: %w", role, ErrContextMissing)
+}
+
+func getDefaultType(role string) (string, error) {
+	f, err := os.Open(filepath.Join(policyRoot(), defaultType))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return getDefaultTypeFromReader(role, f)
+}
+
+// selectRole returns the contexts from conns with the given role, or, if
+// there are none, the context made of user, role, the default type for
+// role as returned by getType, and level, if it is verified.
+func selectRole(conns []string, user, role, level string, getType func(string) (string, error), verifier func(string) error) ([]string, error) {
+	var sel []string
+	for _, conn := range conns {
+		if parts := strings.SplitN(conn, ":", 4); len(parts) > 2 && parts[1] == role {
+			sel = append(sel, conn)
+		}
+	}
+	if len(sel) > 0 {
+		return sel, nil
+	}
+	typ, err := getType(role)
+	if err != nil {
+		return nil, err
+	}
+	conn := Context{"user": user, "role": role, "type": typ, "level": level}.get()
+	if err = verifier(conn); err != nil {
+		return nil, fmt.Errorf("role %s is not reachable: %w", role, err)
+	}
+	return []string{conn}, nil
+}
+
+func computeLoginContext(linuxUser, callerContext string, opts *LoginContextOptions) (*LoginContext, error) {
+	seUser, userRange, err := getSeUserByNameForService(linuxUser, opts.Service)
+	if err != nil {
+		return nil, err
+	}
+	level := userRange
+	if opts.Level != "" && userRange != "" {
+		level, err = calculateGlbLub(opts.Level, userRange)
+		if err != nil {
+			return nil, fmt.Errorf("requested level %s is not within range %s of SELinux user %s: %w", opts.Level, userRange, seUser, err)
+		}
+	}
+	if callerContext == "" {
+		callerContext, err = CurrentLabel()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conns, err := getOrderedContextList(seUser, level, callerContext)
+	if err != nil {
+		return nil, fmt.Errorf("no context for SELinux user %s from %s: %w", seUser, callerContext, err)
+	}
+	if opts.Role != "" {
+		conns, err = selectRole(conns, seUser, opts.Role, level, getDefaultType, securityCheckContext)
+		if err != nil {
+			return nil, err
+		}
 	}
-	return "", "", false
+	return &LoginContext{
+		SEUser:       seUser,
+		Level:        level,
+		Context:      conns[0],
+		Alternatives: conns[1:],
+	}, nil
 }
 
 func setProcessKind(cLabel string, k ProcessKind) (string, error) {
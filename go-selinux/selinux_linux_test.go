@@ -7,9 +7,11 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"testing"
@@ -147,6 +149,275 @@ func TestSetProcessKind(t *testing.T) {
 	})
 }
 
+func TestChconWithJournal(t *testing.T) {
+	if !GetEnabled() {
+		t.Skip("SELinux not enabled, skipping.")
+	}
+
+	const con = "system_u:object_r:container_file_t:s0:c1,c2"
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	orig := map[string]string{}
+	for _, p := range []string{dir, file} {
+		l, err := LfileLabel(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		orig[p] = l
+	}
+
+	var buf bytes.Buffer
+	if err := ChconWithJournal(dir, con, true, NewJournal(&buf)); err != nil {
+		t.Fatal(err)
+	}
+	for p := range orig {
+		if l, _ := LfileLabel(p); l != con {
+			t.Fatalf("%s: want label %q, got %q", p, con, l)
+		}
+	}
+
+	journal := buf.Bytes()
+	if err := RestoreJournal(dir, bytes.NewReader(journal)); err != nil {
+		t.Fatal(err)
+	}
+	for p, want := range orig {
+		if l, _ := LfileLabel(p); l != want {
+			t.Errorf("%s: want restored label %q, got %q", p, want, l)
+		}
+	}
+
+	// Paths reached through a symlink are not restored.
+	outside := t.TempDir()
+	if err := os.Rename(file, filepath.Join(outside, "file")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, dir); err != nil {
+		t.Fatal(err)
+	}
+	parent := filepath.Dir(dir)
+	if err := Chcon(filepath.Join(outside, "file"), con, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := RestoreJournal(parent, bytes.NewReader(journal)); err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := LfileLabel(filepath.Join(outside, "file")); l != con {
+		t.Errorf("label restored through a symlink: want %q, got %q", con, l)
+	}
+}
+
+func TestJournalLabel(t *testing.T) {
+	const con = "system_u:object_r:container_file_t:s0:c1,c2"
+
+	var buf bytes.Buffer
+	j := NewJournal(&buf)
+	for _, tc := range []struct {
+		err     error
+		label   string
+		wantErr error
+	}{
+		{label: con},
+		{err: &os.PathError{Op: "lgetxattr", Path: "/a", Err: unix.ENOENT}},
+		{err: &os.PathError{Op: "lgetxattr", Path: "/a", Err: unix.ENODATA}},
+		{err: &os.PathError{Op: "lgetxattr", Path: "/a", Err: unix.ENOTSUP}},
+		{err: &os.PathError{Op: "lgetxattr", Path: "/a", Err: unix.EACCES}, wantErr: unix.EACCES},
+		{err: &os.PathError{Op: "lgetxattr", Path: "/a", Err: unix.EIO}, wantErr: unix.EIO},
+	} {
+		err := journalLabel(j, "/a", con, func() (string, error) {
+			return tc.label, tc.err
+		})
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%v: want %v, got %v", tc.err, tc.wantErr, err)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Errorf("unexpected records %q", buf.String())
+	}
+
+	if err := journalLabel(j, "/a", con, func() (string, error) {
+		return "system_u:object_r:user_home_t:s0", nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readJournal(&buf)
+	if err != nil || len(entries) != 1 || entries[0].path != "/a" {
+		t.Errorf("want one entry for /a, got %+v (err: %v)", entries, err)
+	}
+}
+
+func TestRelabelLevel(t *testing.T) {
+	if !GetEnabled() {
+		t.Skip("SELinux not enabled, skipping.")
+	}
+
+	const (
+		rw = "system_u:object_r:container_file_t:s0:c1,c2"
+		ro = "system_u:object_r:container_ro_file_t:s0:c1,c2"
+	)
+	dir := t.TempDir()
+	labels := map[string]string{dir: rw}
+	for name, label := range map[string]string{"rw": rw, "ro": ro} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		labels[p] = label
+	}
+	for p, label := range labels {
+		if err := SetFileLabel(p, label); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := RelabelLevel(dir, "s0:c3,c4,c5", &RelabelLevelOptions{Types: []string{"container_file_t"}}); err != nil {
+		t.Fatal(err)
+	}
+	for p, label := range labels {
+		want := strings.Replace(label, "s0:c1,c2", "s0:c3.c5", 1)
+		if strings.Contains(label, "container_ro_file_t") {
+			want = label
+		}
+		if l, _ := LfileLabel(p); l != want {
+			t.Errorf("%s: want label %q, got %q", p, want, l)
+		}
+	}
+
+	if err := RelabelLevel(dir, "s0:c3", nil); err != nil {
+		t.Fatal(err)
+	}
+	for p, label := range labels {
+		want := strings.Replace(label, "s0:c1,c2", "s0:c3", 1)
+		if l, _ := LfileLabel(p); l != want {
+			t.Errorf("%s: want label %q, got %q", p, want, l)
+		}
+	}
+
+	if err := RelabelLevel(dir, "bad", nil); !errors.Is(err, ErrLevelSyntax) {
+		t.Errorf("want %v, got %v", ErrLevelSyntax, err)
+	}
+}
+
+func TestFileLabelFd(t *testing.T) {
+	if !GetEnabled() {
+		t.Skip("SELinux not enabled, skipping.")
+	}
+
+	const con = "system_u:object_r:container_file_t:s0:c1,c2"
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := FsetFileLabel(f, con); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := FfileLabel(f); err != nil || l != con {
+		t.Fatalf("FfileLabel: want %q, got %q (err: %v)", con, l, err)
+	}
+
+	// A symlink opened with O_PATH|O_NOFOLLOW can be relabeled
+	// without affecting its target.
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(file, link); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := unix.Open(link, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fd)
+	const linkCon = "system_u:object_r:container_file_t:s0:c3,c4"
+	if err := SetFileLabelFd(uintptr(fd), linkCon); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := FileLabelFd(uintptr(fd)); err != nil || l != linkCon {
+		t.Fatalf("FileLabelFd: want %q, got %q (err: %v)", linkCon, l, err)
+	}
+	if l, _ := LfileLabel(link); l != linkCon {
+		t.Errorf("%s: want label %q, got %q", link, linkCon, l)
+	}
+	if l, _ := FileLabel(file); l != con {
+		t.Errorf("%s: want label %q, got %q", file, con, l)
+	}
+}
+
+func TestWithXattrFd(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	// The file is not readable without CAP_DAC_OVERRIDE, which must not
+	// matter, as it is never reopened.
+	if err := os.WriteFile(file, nil, 0o200); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(file, link); err != nil {
+		t.Fatal(err)
+	}
+	pd := newProcFdDir()
+	defer pd.close()
+
+	// A directory is reopened, so fsetxattr works.
+	dfd, err := unix.Open(dir, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(dfd)
+	if err := unix.Fsetxattr(dfd, "user.test", []byte("x"), 0); !errors.Is(err, unix.EBADF) {
+		t.Fatalf("want %v for O_PATH descriptor, got %v", unix.EBADF, err)
+	}
+	err = withXattrFd(pd, dfd, func(fd int) error {
+		return fsetxattr(fd, "user.test", []byte("x"))
+	}, func(string) error {
+		t.Error("directory accessed by name")
+		return nil
+	})
+	if errors.Is(err, unix.ENOTSUP) {
+		t.Skip("user xattrs not supported")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val, err := lgetxattr(dir, "user.test"); err != nil || string(val) != "x" {
+		t.Errorf("want %q, got %q (err: %v)", "x", val, err)
+	}
+
+	// Anything else is accessed by its name in /proc/self/fd, from the
+	// same threads.
+	for _, p := range []string{file, link} {
+		fd, err := unix.Open(p, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unix.Close(fd)
+		err = withXattrFd(pd, fd, func(int) error {
+			t.Errorf("%s reopened", p)
+			return nil
+		}, func(name string) error {
+			target, err := os.Readlink(name)
+			if err == nil && target != p {
+				t.Errorf("%s: want %q, got %q", name, p, target)
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pd.threads != 1 {
+		t.Errorf("want 1 thread, got %d", pd.threads)
+	}
+}
+
 func TestKVMContainerLabel(t *testing.T) {
 	if !GetEnabled() {
 		t.Skip("SELinux not enabled, skipping.")
@@ -181,6 +452,192 @@ func TestInitContainerLabel(t *testing.T) {
 	ReleaseLabel(plabel)
 }
 
+func TestNewContainerLabel(t *testing.T) {
+	if !GetEnabled() {
+		t.Skip("SELinux not enabled, skipping.")
+	}
+
+	if _, err := NewContainerLabel(ProcessKind(42), nil); err == nil {
+		t.Error("expected error for ProcessKind(42), got nil")
+	}
+
+	for _, kind := range []ProcessKind{ProcessKindRegular, ProcessKindInit, ProcessKindKVM} {
+		c, err := NewContainerLabel(kind, nil)
+		if err != nil {
+			t.Fatalf("kind %d: %v", kind, err)
+		}
+		if c.ProcessLabel == "" || c.MountLabel == "" {
+			t.Fatalf("kind %d: got empty labels %+v", kind, c)
+		}
+		t.Log(c.ProcessLabel, c.MountLabel, c.ROFileLabel)
+		if _, err := CanonicalizeContext(c.ProcessLabel); err != nil {
+			t.Fatal(err)
+		}
+		pcon, _ := NewContext(c.ProcessLabel)
+		mcon, _ := NewContext(c.MountLabel)
+		if pcon["level"] != mcon["level"] {
+			t.Errorf("kind %d: levels differ: %q vs %q", kind, c.ProcessLabel, c.MountLabel)
+		}
+		if strings.Contains(pcon["level"], ":c") {
+			if !c.OwnsLevel() {
+				t.Errorf("kind %d: expected level to be owned", kind)
+			}
+			if err := CheckLabel(c.ProcessLabel); !errors.Is(err, ErrMCSAlreadyExists) {
+				t.Errorf("kind %d: expected level to be reserved, got %v", kind, err)
+			}
+		}
+		for i := 0; i < 2; i++ {
+			if err := c.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if c.OwnsLevel() {
+			t.Errorf("kind %d: level still owned after Close", kind)
+		}
+		if err := CheckLabel(c.ProcessLabel); err != nil {
+			t.Errorf("kind %d: level still reserved after Close: %v", kind, err)
+		}
+	}
+
+	// A level that is already reserved is shared, not owned.
+	const shared = "system_u:system_r:container_t:s0:c11,c12"
+	if err := ReserveLabelV2(shared); err != nil {
+		t.Fatal(err)
+	}
+	defer ReleaseLabel(shared)
+	c, err := NewContainerLabel(ProcessKindRegular, &ContainerLabelOptions{
+		Level:    "s0:c11,c12",
+		FileType: "container_ro_file_t",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.OwnsLevel() {
+		t.Error("expected shared level not to be owned")
+	}
+	mcon, _ := NewContext(c.MountLabel)
+	if mcon["type"] != "container_ro_file_t" || mcon["level"] != "s0:c11,c12" {
+		t.Errorf("options not applied to mount label %q", c.MountLabel)
+	}
+	_ = c.Close()
+	if err := CheckLabel(shared); !errors.Is(err, ErrMCSAlreadyExists) {
+		t.Errorf("shared level released by Close: %v", err)
+	}
+}
+
+func TestCheckLabelComponents(t *testing.T) {
+	// A tiny policy: user_u may have roles object_r and user_r and range
+	// s0-s0:c0.c9; user_r is authorized for user_t only.
+	check := func(val string) error {
+		con, err := NewContext(val)
+		if err != nil {
+			return unix.EINVAL
+		}
+		if con["user"] != "user_u" && con["user"] != "system_u" {
+			return unix.EINVAL
+		}
+		if con["type"] != "user_t" && con["type"] != "file_t" {
+			return unix.EINVAL
+		}
+		low, high, _ := strings.Cut(con["level"], "-")
+		for _, l := range []string{low, high} {
+			if l != "" && l != "s0" && !strings.HasPrefix(l, "s0:c") {
+				return unix.EINVAL
+			}
+		}
+		if con["role"] == "object_r" {
+			return nil
+		}
+		if con["role"] != "user_r" || con["type"] != "user_t" {
+			return unix.EINVAL
+		}
+		if con["level"] != "s0" && con["level"] != "s0:c1,c2" {
+			return unix.EINVAL
+		}
+		return nil
+	}
+	userContexts := func(user string) ([]string, error) {
+		if user != "user_u" {
+			return nil, nil
+		}
+		return parseUserContexts("1\x00user_u:user_r:user_t:s0-s0:c0.c9\x00")
+	}
+	const ref = "system_u:object_r:file_t:s0"
+
+	for _, tc := range []struct {
+		label, component string
+		err              error
+	}{
+		{label: "user_u:user_r:user_t:s0:c1,c2"},
+		{label: "user_u:object_r:file_t:s0:c100"},
+		{"user_u:user_r:user_t:s1", "level", ErrNotDefined},
+		{"bad_u:user_r:user_t:s0", "user", ErrNotDefined},
+		{"user_u:user_r:bad_t:s0", "type", ErrNotDefined},
+		{"user_u:bad_r:user_t:s0", "role", ErrNotAuthorized},
+		{"user_u:user_r:file_t:s0", "role", ErrNotAuthorized},
+		{"user_u:user_r:user_t:s0:c100", "level", ErrNotAuthorized},
+	} {
+		err := checkLabelComponents(tc.label, ref, check, userContexts)
+		if tc.err == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.label, err)
+			}
+			continue
+		}
+		var cErr *LabelComponentError
+		if !errors.As(err, &cErr) || !errors.Is(err, tc.err) || cErr.Component != tc.component {
+			t.Errorf("%s: want %s %v error, got %v", tc.label, tc.component, tc.err, err)
+		}
+	}
+	if err := checkLabelComponents("bad", ref, check, userContexts); !errors.Is(err, ErrInvalidLabel) {
+		t.Errorf("want %v, got %v", ErrInvalidLabel, err)
+	}
+
+	// Errors other than EINVAL mean the label can not be checked.
+	for _, errno := range []error{unix.EACCES, unix.ENOENT, unix.EROFS} {
+		eCheck := func(string) error { return &os.PathError{Op: "write", Path: "context", Err: errno} }
+		if err := checkLabelComponents("bad_u:user_r:user_t:s0", ref, eCheck, userContexts); err != nil {
+			t.Errorf("%v: want no error, got %v", errno, err)
+		}
+	}
+
+	// Without the user contexts, the component can not be told.
+	noUser := func(string) ([]string, error) { return nil, unix.ENOENT }
+	err := checkLabelComponents("user_u:bad_r:user_t:s0", ref, check, noUser)
+	var cErr *LabelComponentError
+	if err == nil || errors.As(err, &cErr) {
+		t.Errorf("want a generic error, got %v", err)
+	}
+
+	if _, err := parseUserContexts("2\x00user_u:user_r:user_t:s0\x00"); err == nil {
+		t.Error("want error for a bad count")
+	}
+}
+
+func TestValidateLabel(t *testing.T) {
+	if !GetEnabled() {
+		t.Skip("SELinux not enabled, skipping.")
+	}
+
+	plabel, err := KVMContainerLabel()
+	if err != nil || plabel == "" {
+		t.Skip("no container process label in policy, skipping.")
+	}
+	ReleaseLabel(plabel)
+	if err := ValidateLabel(plabel); err != nil {
+		t.Fatal(err)
+	}
+	pcon, _ := NewContext(plabel)
+	pcon["type"] = "no_such_type_t"
+	var cErr *LabelComponentError
+	if err := ValidateLabel(pcon.Get()); !errors.As(err, &cErr) || cErr.Component != "type" {
+		t.Errorf("want type error, got %v", err)
+	}
+	if _, err := NewContainerLabel(ProcessKindRegular, &ContainerLabelOptions{Type: "no_such_type_t"}); !errors.As(err, &cErr) {
+		t.Errorf("want LabelComponentError, got %v", err)
+	}
+}
+
 func TestDuplicateLabel(t *testing.T) {
 	secopt, err := DupSecOpt("system_u:system_r:container_t:s0:c1,c2")
 	if err != nil {
@@ -291,6 +748,67 @@ func TestExecLabel(t *testing.T) {
 	}
 }
 
+func TestWithFSCreateLabel(t *testing.T) {
+	if !GetEnabled() {
+		t.Skip("SELinux not enabled, skipping.")
+	}
+
+	const label = "system_u:object_r:container_file_t:s0:c1,c2"
+	file := filepath.Join(t.TempDir(), "file")
+	err := WithFSCreateLabel(label, func() error {
+		if l, err := FSCreateLabel(); err != nil || l != label {
+			t.Errorf("FSCreateLabel: want %q, got %q (err: %v)", label, l, err)
+		}
+		return os.WriteFile(file, nil, 0o600)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l, err := FileLabel(file); err != nil || l != label {
+		t.Errorf("FileLabel: want %q, got %q (err: %v)", label, l, err)
+	}
+
+	// The previous (default) label is restored.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if l, err := FSCreateLabel(); err != nil || l != "" {
+		t.Errorf("FSCreateLabel: want \"\", got %q (err: %v)", l, err)
+	}
+}
+
+func TestStartWithExecLabel(t *testing.T) {
+	if !GetEnabled() {
+		t.Skip("SELinux not enabled, skipping.")
+	}
+
+	label, err := CurrentLabel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	cmd := exec.Command("cat", "/proc/self/attr/current")
+	cmd.Stdout = &out
+	if err := RunWithExecLabel(cmd, label); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimRight(out.String(), "\x00\n"); got != label {
+		t.Errorf("want command label %q, got %q", label, got)
+	}
+}
+
+func TestStartWithExecLabelNotExecutable(t *testing.T) {
+	// Even root can not execute a file without any execute bits.
+	name := filepath.Join(t.TempDir(), "noexec")
+	if err := os.WriteFile(name, []byte("#!/bin/sh\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	err := StartWithExecLabel(exec.Command(name), "")
+	var tErr *ExecTransitionError
+	if !errors.Is(err, os.ErrPermission) || errors.As(err, &tErr) {
+		t.Errorf("want a plain permission error, got %v", err)
+	}
+}
+
 func TestKeyLabel(t *testing.T) {
 	if !GetEnabled() {
 		t.Skip("SELinux not enabled, skipping.")
@@ -547,6 +1065,65 @@ func TestComputeCreateContext(t *testing.T) {
 	}
 }
 
+func TestSharedLevel(t *testing.T) {
+	tests := []struct {
+		expectedErr   error
+		labels        []string
+		expectedLevel string
+	}{
+		{
+			labels:        []string{"u:r:t:s0:c1,c2"},
+			expectedLevel: "s0:c1,c2",
+		},
+		{
+			labels:        []string{"u:r:t:s0:c1,c2,c3", "u:r:t:s0:c2,c3,c4", "u:r:t:s0:c0.c10"},
+			expectedLevel: "s0:c2,c3",
+		},
+		{
+			// The high level of a range is used.
+			labels:        []string{"u:r:t:s2:c1.c5-s3:c0.c1023", "u:r:t:s1:c3.c9"},
+			expectedLevel: "s1:c3.c9",
+		},
+		{
+			labels:        []string{"system_u:system_r:container_t:s0:c1,c2", "unconfined_u:unconfined_r:unconfined_t:s0-s0:c0.c1023"},
+			expectedLevel: "s0:c1,c2",
+		},
+		{
+			labels:        []string{"u:r:t:s0", "u:r:t:s0"},
+			expectedLevel: "s0",
+		},
+		{
+			labels:      []string{"u:r:t:s0:c1,c2", "u:r:t:s0:c3,c4"},
+			expectedErr: ErrNoSharedLevel,
+		},
+		{
+			labels:      []string{"u:r:t:s0:c1,c2", "u:r:t:s0"},
+			expectedErr: ErrNoSharedLevel,
+		},
+		{
+			labels:      []string{"u:r:t:s0:c1", "u:r:t"},
+			expectedErr: ErrLevelSyntax,
+		},
+		{
+			labels:      []string{"bad"},
+			expectedErr: ErrInvalidLabel,
+		},
+		{
+			expectedErr: ErrInvalidLabel,
+		},
+	}
+	for _, tt := range tests {
+		got, err := SharedLevel(tt.labels...)
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("SharedLevel(%q): want error %v, got %v", tt.labels, tt.expectedErr, err)
+			continue
+		}
+		if got != tt.expectedLevel {
+			t.Errorf("SharedLevel(%q): want %q, got %q", tt.labels, tt.expectedLevel, got)
+		}
+	}
+}
+
 func TestGlbLub(t *testing.T) {
 	tests := []struct {
 		expectedErr   error
@@ -822,6 +1399,34 @@ bob:staff_u:s0
 	}
 }
 
+func TestServiceUserFromReader(t *testing.T) {
+	const logins = `# logins/bob
+sshd:staff_u:s0-s0:c0.c100
+crond:user_u
+*:guest_u:s0
+`
+	for _, tc := range []struct {
+		service, seUser, level string
+	}{
+		{"sshd", "staff_u", "s0-s0:c0.c100"},
+		{"crond", "user_u", ""},
+		{"login", "guest_u", "s0"},
+	} {
+		seUser, level, ok, err := getServiceUserFromReader(tc.service, strings.NewReader(logins))
+		if err != nil || !ok || seUser != tc.seUser || level != tc.level {
+			t.Errorf("%s: want %s, %s, got %s, %s, %v (err: %v)", tc.service, tc.seUser, tc.level, seUser, level, ok, err)
+		}
+	}
+	// A "*" line is only a fallback, even if it comes first.
+	seUser, level, ok, err := getServiceUserFromReader("sshd", strings.NewReader("*:guest_u:s0\nsshd:staff_u:s0-s0:c0.c100\n"))
+	if err != nil || !ok || seUser != "staff_u" || level != "s0-s0:c0.c100" {
+		t.Errorf("want staff_u, s0-s0:c0.c100, got %s, %s, %v (err: %v)", seUser, level, ok, err)
+	}
+	if _, _, ok, err := getServiceUserFromReader("sshd", strings.NewReader("crond:user_u\n")); ok || err != nil {
+		t.Errorf("want no match, got %v (err: %v)", ok, err)
+	}
+}
+
 func TestContextWithLevel(t *testing.T) {
 	want := "bob:sysadm_r:sysadm_t:SystemLow-SystemHigh"
 
@@ -926,6 +1531,129 @@ fake_r:fake_t:s0                 baz_r:baz_t:s0   sysadm_r:sysadm_t:s0
 	})
 }
 
+func TestComputeLoginContext(t *testing.T) {
+	if !GetEnabled() {
+		t.Skip("SELinux not enabled, skipping.")
+	}
+
+	lc, err := ComputeLoginContext("root", "", nil)
+	if err != nil {
+		t.Skipf("no login context for root: %v", err)
+	}
+	t.Logf("%+v", lc)
+	if err := SecurityCheckContext(lc.Context); err != nil {
+		t.Errorf("invalid login context: %v", err)
+	}
+	con, _ := NewContext(lc.Context)
+	if con["user"] != lc.SEUser || con["level"] != lc.Level {
+		t.Errorf("context %q does not match user %q and level %q", lc.Context, lc.SEUser, lc.Level)
+	}
+}
+
+func TestOrderedContextsFromReaders(t *testing.T) {
+	reader := func(buf string) openReaderCloser {
+		return func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(buf)), nil
+		}
+	}
+	notExist := func() (io.ReadCloser, error) {
+		return nil, os.ErrNotExist
+	}
+	// baz_t is not a valid type.
+	verifier := func(con string) error {
+		if strings.Contains(con, ":baz_t:") {
+			return errors.New("invalid context")
+		}
+		return nil
+	}
+	const (
+		defaultBuff = `
+system_r:sshd_t:s0	user_r:user_t:s0 staff_r:staff_t:s0
+staff_r:staff_t:s0	staff_r:staff_t:s0
+`
+		failsafeBuff = "sysadm_r:sysadm_t:s0"
+	)
+
+	for _, tc := range []struct {
+		name       string
+		userRdr    openReaderCloser
+		defaultBuf string
+		want       []string
+	}{
+		{
+			name: "user context file",
+			userRdr: reader(`# COMMENT
+system_r:sshd_t:s0	baz_r:baz_t:s0 staff_r:staff_t:s0 sysadm_r:sysadm_t:s0 staff_r:staff_t:s0
+`),
+			defaultBuf: defaultBuff,
+			want:       []string{"staff_u:staff_r:staff_t:s0-s0:c0.c1023", "staff_u:sysadm_r:sysadm_t:s0-s0:c0.c1023"},
+		},
+		{
+			name:       "no user context file",
+			userRdr:    notExist,
+			defaultBuf: defaultBuff,
+			want:       []string{"staff_u:user_r:user_t:s0-s0:c0.c1023", "staff_u:staff_r:staff_t:s0-s0:c0.c1023"},
+		},
+		{
+			name:       "failsafe context",
+			userRdr:    reader("system_r:sshd_t:s0 baz_r:baz_t:s0\n"),
+			defaultBuf: "staff_r:staff_t:s0 user_r:user_t:s0\n",
+			want:       []string{"staff_u:sysadm_r:sysadm_t:s0-s0:c0.c1023"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := defaultSECtx{
+				user:            "staff_u",
+				level:           "s0-s0:c0.c1023",
+				scon:            "system_u:system_r:sshd_t:s0-s0:c0.c1023",
+				openUserRdr:     tc.userRdr,
+				openDefaultRdr:  reader(tc.defaultBuf),
+				openFailsafeRdr: reader(failsafeBuff),
+				verifier:        verifier,
+			}
+			got, err := getOrderedContextsFromReaders(&c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("want %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDefaultType(t *testing.T) {
+	const buf = `# default_type
+auditadm_r:auditadm_t
+
+staff_r:staff_t
+user_r:user_t
+`
+	getType := func(role string) (string, error) {
+		return getDefaultTypeFromReader(role, strings.NewReader(buf))
+	}
+	if typ, err := getType("staff_r"); err != nil || typ != "staff_t" {
+		t.Errorf("staff_r: want staff_t, got %q (err: %v)", typ, err)
+	}
+	if _, err := getType("sysadm_r"); !errors.Is(err, ErrContextMissing) {
+		t.Errorf("sysadm_r: want %v, got %v", ErrContextMissing, err)
+	}
+
+	verifier := func(string) error { return nil }
+	conns := []string{"staff_u:staff_r:staff_t:s0", "staff_u:sysadm_r:sysadm_t:s0", "staff_u:staff_r:other_t:s0"}
+	got, err := selectRole(conns, "staff_u", "staff_r", "s0", getType, verifier)
+	if err != nil || !slices.Equal(got, []string{conns[0], conns[2]}) {
+		t.Errorf("staff_r: unexpected contexts %q (err: %v)", got, err)
+	}
+	got, err = selectRole(conns, "staff_u", "auditadm_r", "s0", getType, verifier)
+	if err != nil || !slices.Equal(got, []string{"staff_u:auditadm_r:auditadm_t:s0"}) {
+		t.Errorf("auditadm_r: unexpected contexts %q (err: %v)", got, err)
+	}
+	if _, err = selectRole(conns, "staff_u", "user_r", "s0", getType, func(string) error { return os.ErrInvalid }); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("user_r: want %v, got %v", os.ErrInvalid, err)
+	}
+}
+
 func TestReserveLabelNoMCS(t *testing.T) {
 	const label = "system_u:system_r:container_runtime_t:s0"
 
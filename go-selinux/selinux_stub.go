@@ -2,6 +2,11 @@
 
 package selinux
 
+import (
+	"io"
+	"os"
+)
+
 func readConThreadSelf(string) (string, error) {
 	return "", nil
 }
@@ -36,6 +41,46 @@ func lFileLabel(string) (string, error) {
 	return "", nil
 }
 
+func fSetFileLabel(int, string) error {
+	return nil
+}
+
+func fFileLabel(int) (string, error) {
+	return "", nil
+}
+
+func normalizeLevel(level string) (string, error) {
+	return level, nil
+}
+
+func policyFileContexts() ([]string, []string) {
+	return nil, nil
+}
+
+func levelHasCategory(string, uint) bool {
+	return false
+}
+
+func sharedLevel([]string) (string, error) {
+	return "", nil
+}
+
+func relabelLevel(string, string, *RelabelLevelOptions) error {
+	return nil
+}
+
+func isNoLabel(error) bool {
+	return false
+}
+
+func setFileLabelFile(*os.File, string) error {
+	return nil
+}
+
+func fileLabelFile(*os.File) (string, error) {
+	return "", nil
+}
+
 func setFSCreateLabel(string) error {
 	return nil
 }
@@ -142,6 +187,14 @@ func chcon(string, string, bool) error {
 	return nil
 }
 
+func chconWithJournal(string, string, bool, *Journal) error {
+	return nil
+}
+
+func restoreJournal(string, io.Reader) error {
+	return nil
+}
+
 func dupSecOpt(string) ([]string, error) {
 	return nil, nil
 }
@@ -161,3 +214,27 @@ func label(_ string) string {
 func setProcessKind(string, ProcessKind) (string, error) {
 	return "", nil
 }
+
+func newContainerLabel(ProcessKind, *ContainerLabelOptions) (*ContainerLabel, error) {
+	return &ContainerLabel{}, nil
+}
+
+func validateLabel(string) error {
+	return nil
+}
+
+func computeLoginContext(string, string, *LoginContextOptions) (*LoginContext, error) {
+	return &LoginContext{}, nil
+}
+
+func getOrderedContextList(string, string, string) ([]string, error) {
+	return nil, nil
+}
+
+func getDefaultType(string) (string, error) {
+	return "", nil
+}
+
+func getSeUserByNameForService(string, string) (string, string, error) {
+	return "", "", nil
+}
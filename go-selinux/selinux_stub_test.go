@@ -3,6 +3,11 @@
 package selinux
 
 import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -123,4 +128,98 @@ func TestSELinuxStubs(t *testing.T) {
 	if _, err = SetProcessKind("", ProcessKindRegular); err != nil {
 		t.Error(err)
 	}
+	if err = ChconWithJournal(tmpDir, testLabel, true, NewJournal(io.Discard)); err != nil {
+		t.Error(err)
+	}
+	if err = RestoreJournal(tmpDir, strings.NewReader("")); err != nil {
+		t.Error(err)
+	}
+	if err = SetFileLabelFd(0, testLabel); err != nil {
+		t.Error(err)
+	}
+	if _, err = FileLabelFd(0); err != nil {
+		t.Error(err)
+	}
+	if err = FsetFileLabel(os.Stdin, testLabel); err != nil {
+		t.Error(err)
+	}
+	if _, err = FfileLabel(os.Stdin); err != nil {
+		t.Error(err)
+	}
+	fn := func() error { return nil }
+	if err = WithFSCreateLabel(testLabel, fn); err != nil {
+		t.Error(err)
+	}
+	if err = WithExecLabel(testLabel, fn); err != nil {
+		t.Error(err)
+	}
+	if err = WithSocketLabel(testLabel, fn); err != nil {
+		t.Error(err)
+	}
+	if err = WithKeyLabel(testLabel, fn); err != nil {
+		t.Error(err)
+	}
+	f, err := CreateWithLabel(filepath.Join(tmpDir, "file"), testLabel, nil)
+	if err != nil {
+		t.Error(err)
+	} else {
+		f.Close()
+	}
+	f, err = CreateTempWithLabel(tmpDir, "tmp", testLabel, nil)
+	if err != nil {
+		t.Error(err)
+	} else {
+		f.Close()
+	}
+	if err = MkdirAllWithLabel(filepath.Join(tmpDir, "a", "b"), 0o700, testLabel, nil); err != nil {
+		t.Error(err)
+	}
+	if err = RelabelLevel(tmpDir, "s0:c1", nil); err != nil {
+		t.Error(err)
+	}
+	// The command fails fast without running anything external; its
+	// error must be passed through unchanged.
+	noCmd := filepath.Join(tmpDir, "no-such-command")
+	want := exec.Command(noCmd).Run()
+	if err = RunWithExecLabel(exec.Command(noCmd), testLabel); err == nil || want == nil || err.Error() != want.Error() {
+		t.Errorf("expected error %v, got %v", want, err)
+	}
+	tr, err := LoadTranslator()
+	if err != nil {
+		t.Error(err)
+	} else if l, _ := tr.RawToTrans("s0"); l != "s0" {
+		t.Errorf("expected level to be left as is, got %q", l)
+	}
+	if _, err = NewTranslator(strings.NewReader("s0=SystemLow")); err != nil {
+		t.Error(err)
+	}
+	tc := &TransClient{Socket: filepath.Join(tmpDir, "none.sock")}
+	trans, err := tc.RawToTrans("system_u:object_r:etc_t:s0")
+	if err != nil || trans != "system_u:object_r:etc_t:s0" {
+		t.Errorf("expected context to be left as is, got %q (err: %v)", trans, err)
+	}
+	if _, _, err = SEUserByNameForService("root", "sshd"); err != nil {
+		t.Error(err)
+	}
+	if _, err = GetOrderedContextList("user_u", "s0", testLabel); err != nil {
+		t.Error(err)
+	}
+	if _, err = GetDefaultType("user_r"); err != nil {
+		t.Error(err)
+	}
+	if _, err = ComputeLoginContext("root", testLabel, nil); err != nil {
+		t.Error(err)
+	}
+	if err = ValidateLabel(testLabel); err != nil {
+		t.Error(err)
+	}
+	c, err := NewContainerLabel(ProcessKindRegular, nil)
+	if err != nil {
+		t.Error(err)
+	} else if c.ProcessLabel != "" || c.MountLabel != "" {
+		t.Errorf("expected empty labels, got %+v", c)
+	}
+	if err = c.Close(); err != nil {
+		t.Error(err)
+	}
 }
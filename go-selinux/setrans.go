@@ -0,0 +1,93 @@
+package selinux
+
+import (
+	"io"
+	"math/big"
+)
+
+// Translator translates MLS/MCS levels and ranges between their raw form
+// (such as "s0:c0.c1023") and the human-readable one (such as
+// "SystemHigh"), as defined by a setrans.conf(5) file, the way mcstransd
+// does.
+//
+// The following setrans.conf rules are supported:
+//
+//   - raw=translation, for a level or a range translated as a whole;
+//   - Base=NAME, after which raw=translation rules define base levels
+//     instead, as which levels are translated along with modifiers;
+//   - ModifierGroup=NAME, after which raw=translation rules define the
+//     words for categories added to a base level, and Whitespace=CHARS,
+//     Join=STRING, Prefix=STRING, Suffix=STRING and Default=CATEGORIES
+//     rules set how these words are written and read, and which
+//     categories the group has when none of its words are given. A group
+//     may have several prefixes, all of which are read, and the first of
+//     which is written. Categories marked with '~', such as in
+//     "~c200.c511=USA", are inverse: a word or default having such a
+//     category means the level does not have it;
+//   - Include=PATTERN, to read the rules from other files.
+//
+// Levels with no translation are left as is.
+type Translator struct {
+	exact  []transRule // Levels and ranges translated as a whole.
+	bases  []transBase
+	groups []*modifierGroup
+}
+
+type transRule struct {
+	raw, trans string
+}
+
+type transBase struct {
+	word string
+	sens int
+	cats *big.Int
+}
+
+type transWord struct {
+	word string
+	cats *big.Int
+}
+
+// modifierGroup is a ModifierGroup of a setrans.conf file. The categories
+// of its words and default are stored with the inverse ones flipped, that
+// is, as the XOR of the level categories with inv.
+type modifierGroup struct {
+	name       string
+	whitespace string   // Separators accepted between words.
+	join       string   // Separator between words when writing.
+	prefixes   []string // The first one is used when writing.
+	suffix     string
+	def        *big.Int // Categories when no words are given.
+	words      []transWord
+	cats       *big.Int // All the categories of the group.
+	inv        *big.Int // The inverse categories of the group.
+}
+
+// NewTranslator returns a Translator with the rules read from r, in the
+// setrans.conf(5) format. Include rules refer to files on disk.
+func NewTranslator(r io.Reader) (*Translator, error) {
+	t := &Translator{}
+	if err := t.parse(r, 0); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// LoadTranslator returns a Translator with the rules from the setrans.conf
+// file of the loaded policy, /etc/selinux/{SELINUXTYPE}/setrans.conf.
+func LoadTranslator() (*Translator, error) {
+	return loadTranslator()
+}
+
+// RawToTrans returns the translation of the raw MLS/MCS level or range
+// raw. If there is none, raw itself is returned.
+func (t *Translator) RawToTrans(raw string) (string, error) {
+	return t.rawToTrans(raw)
+}
+
+// TransToRaw returns the raw MLS/MCS level or range for the translation
+// trans. If trans is not a translation, but a valid raw level or range,
+// it is returned in its canonical form.
+func (t *Translator) TransToRaw(trans string) (string, error) {
+	return t.transToRaw(trans)
+}
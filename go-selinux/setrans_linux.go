@@ -0,0 +1,458 @@
+package selinux
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/big"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// maxSetransIncludeDepth limits the nesting of Include rules, to detect
+// include loops.
+const maxSetransIncludeDepth = 8
+
+func loadTranslator() (*Translator, error) {
+	f, err := os.Open(filepath.Join(policyRoot(), "setrans.conf"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return NewTranslator(f)
+}
+
+func (t *Translator) parse(r io.Reader, depth int) error {
+	var (
+		group   *modifierGroup
+		inBase  bool
+		lineNum int
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("setrans: line %d: malformed line", lineNum)
+		}
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		if val == "" {
+			return fmt.Errorf("setrans: line %d: empty value for %s", lineNum, key)
+		}
+
+		var err error
+		switch key {
+		case "Domain":
+			// Only the default domain is supported; ignore.
+		case "Include":
+			err = t.include(val, depth)
+		case "Base":
+			group, inBase = nil, true
+		case "ModifierGroup":
+			group, inBase = &modifierGroup{name: val, def: new(big.Int), cats: new(big.Int), inv: new(big.Int)}, false
+			t.groups = append(t.groups, group)
+		case "Whitespace", "Join", "Prefix", "Suffix", "Default":
+			if group == nil {
+				return fmt.Errorf("setrans: line %d: %s outside of a ModifierGroup", lineNum, key)
+			}
+			err = group.set(key, val)
+		default:
+			switch {
+			case group != nil:
+				err = group.addWord(key, val)
+			case inBase:
+				err = t.addBase(key, val)
+			default:
+				err = t.addExact(key, val)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("setrans: line %d: %w", lineNum, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("setrans: %w", err)
+	}
+	return nil
+}
+
+func (t *Translator) include(pattern string, depth int) error {
+	if depth >= maxSetransIncludeDepth {
+		return fmt.Errorf("too many nested includes at %s", pattern)
+	}
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err = t.includeFile(file, depth+1); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+func (t *Translator) includeFile(file string, depth int) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return t.parse(f, depth)
+}
+
+func (t *Translator) addExact(raw, trans string) error {
+	norm, err := normalizeLevel(raw)
+	if err != nil {
+		return fmt.Errorf("invalid level %q: %w", raw, err)
+	}
+	t.exact = append(t.exact, transRule{raw: norm, trans: trans})
+	return nil
+}
+
+func (t *Translator) addBase(raw, word string) error {
+	l := &level{}
+	if err := l.parseLevel(raw); err != nil {
+		return fmt.Errorf("invalid base level %q: %w", raw, err)
+	}
+	if l.cats == nil {
+		l.cats = new(big.Int)
+	}
+	t.bases = append(t.bases, transBase{word: word, sens: l.sens, cats: l.cats})
+	return nil
+}
+
+func (g *modifierGroup) set(key, val string) error {
+	switch key {
+	case "Whitespace":
+		g.whitespace = val
+	case "Join":
+		g.join = val
+	case "Prefix":
+		g.prefixes = append(g.prefixes, val)
+	case "Suffix":
+		g.suffix = val
+	case "Default":
+		cats, err := g.parseCats(val)
+		if err != nil {
+			return err
+		}
+		g.def = cats
+	}
+	return nil
+}
+
+// parseCats parses the categories of a word or default of g, adding them
+// to the categories of g, and those marked with '~' to its inverse ones.
+func (g *modifierGroup) parseCats(raw string) (*big.Int, error) {
+	cats := new(big.Int)
+	for _, item := range strings.Split(raw, ",") {
+		item, inverse := strings.CutPrefix(item, "~")
+		c, err := catsToBitset(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid categories %q: %w", raw, err)
+		}
+		cats.Or(cats, c)
+		if inverse {
+			g.inv.Or(g.inv, c)
+		}
+	}
+	g.cats.Or(g.cats, cats)
+	return cats, nil
+}
+
+func (g *modifierGroup) addWord(raw, word string) error {
+	// Allow the categories to be given along with a sensitivity, which
+	// is ignored, e.g. "s0:c1".
+	if _, c, ok := strings.Cut(raw, ":"); ok {
+		raw = c
+	}
+	cats, err := g.parseCats(raw)
+	if err != nil {
+		return err
+	}
+	g.words = append(g.words, transWord{word: word, cats: cats})
+	return nil
+}
+
+// wordsFor returns the words of g for the categories cats, in the order
+// they are defined, or false if cats can not be expressed using them.
+// Words with more categories are preferred.
+func (g *modifierGroup) wordsFor(cats *big.Int) ([]string, bool) {
+	order := make([]int, len(g.words))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return catCount(g.words[b].cats) - catCount(g.words[a].cats)
+	})
+	rest := new(big.Int).Set(cats)
+	used := make([]bool, len(g.words))
+	for _, i := range order {
+		w := g.words[i]
+		if w.cats.Sign() != 0 && isSubset(w.cats, rest) {
+			rest.AndNot(rest, w.cats)
+			used[i] = true
+		}
+	}
+	if rest.Sign() != 0 {
+		return nil, false
+	}
+	var words []string
+	for i, w := range g.words {
+		if used[i] {
+			words = append(words, w.word)
+		}
+	}
+	return words, true
+}
+
+func (t *Translator) rawToTrans(raw string) (string, error) {
+	r, err := rangeStrToMLSRange(raw)
+	if err != nil {
+		return "", err
+	}
+	norm := r.String()
+	if trans, ok := t.exactTrans(norm); ok {
+		return trans, nil
+	}
+	low, ok := t.levelToTrans(r.low)
+	if !ok {
+		return norm, nil
+	}
+	if r.low.equal(r.high) {
+		return low, nil
+	}
+	high, ok := t.levelToTrans(r.high)
+	if !ok {
+		return norm, nil
+	}
+	return low + "-" + high, nil
+}
+
+func (t *Translator) exactTrans(raw string) (string, bool) {
+	for _, rule := range t.exact {
+		if rule.raw == raw {
+			return rule.trans, true
+		}
+	}
+	return "", false
+}
+
+// levelToTrans translates a single level, either as a whole, or as a base
+// level with modifiers.
+func (t *Translator) levelToTrans(l *level) (string, bool) {
+	cats := l.cats
+	if cats == nil {
+		cats = new(big.Int)
+	}
+	if trans, ok := t.exactTrans(mlsRange{low: l, high: l}.String()); ok {
+		return trans, true
+	}
+
+	// Use the base with the most categories, all of which the level has.
+	var base *transBase
+	for i := range t.bases {
+		b := &t.bases[i]
+		if b.sens == l.sens && isSubset(b.cats, cats) && (base == nil || catCount(b.cats) > catCount(base.cats)) {
+			base = b
+		}
+	}
+	if base == nil {
+		return "", false
+	}
+
+	rest := new(big.Int).AndNot(cats, base.cats)
+	parts := []string{base.word}
+	for _, g := range t.groups {
+		sel := new(big.Int).And(rest, g.cats)
+		rest.AndNot(rest, sel)
+		sel.Xor(sel, g.inv)
+		if sel.Cmp(g.def) == 0 {
+			continue
+		}
+		words, ok := g.wordsFor(sel)
+		if !ok || len(words) == 0 {
+			return "", false
+		}
+		part := strings.Join(words, g.join)
+		if len(g.prefixes) > 0 {
+			part = g.prefixes[0] + " " + part
+		}
+		parts = append(parts, part+g.suffix)
+	}
+	if rest.Sign() != 0 {
+		return "", false
+	}
+	return strings.Join(parts, " "), true
+}
+
+func (t *Translator) transToRaw(trans string) (string, error) {
+	trans = strings.TrimSpace(trans)
+	for _, rule := range t.exact {
+		if rule.trans == trans {
+			return rule.raw, nil
+		}
+	}
+	if l, ok := t.transToLevel(trans); ok {
+		return mlsRange{low: l, high: l}.String(), nil
+	}
+	// Translations may contain '-', so try every split into a range.
+	for i := range len(trans) {
+		if trans[i] != '-' {
+			continue
+		}
+		low, ok := t.transToLevel(strings.TrimSpace(trans[:i]))
+		if !ok {
+			continue
+		}
+		if high, found := t.transToLevel(strings.TrimSpace(trans[i+1:])); found {
+			return mlsRange{low: low, high: high}.String(), nil
+		}
+	}
+	if norm, err := normalizeLevel(trans); err == nil {
+		return norm, nil
+	}
+	return "", fmt.Errorf("no raw level for %q: %w", trans, ErrLevelSyntax)
+}
+
+// transToLevel parses a translated level, a raw one, or a base level with
+// modifiers.
+func (t *Translator) transToLevel(trans string) (*level, bool) {
+	for _, rule := range t.exact {
+		if rule.trans == trans && !strings.Contains(rule.raw, "-") {
+			l := &level{}
+			return l, l.parseLevel(rule.raw) == nil
+		}
+	}
+	if l := new(level); l.parseLevel(trans) == nil {
+		return l, true
+	}
+
+	bases := make([]*transBase, len(t.bases))
+	for i := range t.bases {
+		bases[i] = &t.bases[i]
+	}
+	// Try the longest base words first.
+	slices.SortStableFunc(bases, func(a, b *transBase) int {
+		return len(b.word) - len(a.word)
+	})
+	for _, b := range bases {
+		rest, ok := strings.CutPrefix(trans, b.word)
+		if !ok || (rest != "" && rest[0] != ' ') {
+			continue
+		}
+		if cats, found := t.parseModifiers(rest); found {
+			return &level{sens: b.sens, cats: cats.Or(cats, b.cats)}, true
+		}
+	}
+	return nil, false
+}
+
+// parseModifiers returns the categories for the modifier words in s.
+func (t *Translator) parseModifiers(s string) (*big.Int, bool) {
+	seps := " "
+	for _, g := range t.groups {
+		seps += g.whitespace + g.join
+	}
+	given := make(map[*modifierGroup]*big.Int)
+	var cur *modifierGroup // The group whose prefix was read last.
+	for {
+		s = strings.TrimLeft(s, seps)
+		if s == "" {
+			break
+		}
+		if cur != nil && cur.suffix != "" {
+			if rest, ok := strings.CutPrefix(s, cur.suffix); ok {
+				s, cur = rest, nil
+				continue
+			}
+		}
+		g, n, size := t.matchModifier(s, cur, seps)
+		if g == nil {
+			return nil, false
+		}
+		s = s[size:]
+		if given[g] == nil {
+			given[g] = new(big.Int)
+		}
+		if n < 0 {
+			// Prefix of g.
+			cur = g
+		} else {
+			given[g].Or(given[g], g.words[n].cats)
+		}
+	}
+	cats := new(big.Int)
+	for _, g := range t.groups {
+		c := given[g]
+		if c == nil {
+			c = new(big.Int).Set(g.def)
+		}
+		cats.Or(cats, c.Xor(c, g.inv))
+	}
+	return cats, true
+}
+
+// matchModifier finds the longest group prefix or word s starts with,
+// preferring the words of cur, if any. It returns the group, the index of
+// the word, or -1 for a prefix, and the length of the match.
+func (t *Translator) matchModifier(s string, cur *modifierGroup, seps string) (*modifierGroup, int, int) {
+	var (
+		group *modifierGroup
+		index int
+		size  int
+	)
+	atBoundary := func(n int) bool {
+		if n == len(s) || strings.ContainsRune(seps, rune(s[n])) {
+			return true
+		}
+		for _, g := range t.groups {
+			if g.suffix != "" && strings.HasPrefix(s[n:], g.suffix) {
+				return true
+			}
+		}
+		return false
+	}
+	groups := t.groups
+	if cur != nil {
+		groups = append([]*modifierGroup{cur}, t.groups...)
+	}
+	for _, g := range groups {
+		for _, p := range g.prefixes {
+			if len(p) > size && strings.HasPrefix(s, p) {
+				group, index, size = g, -1, len(p)
+			}
+		}
+		for i, w := range g.words {
+			if len(w.word) > size && strings.HasPrefix(s, w.word) && atBoundary(len(w.word)) {
+				group, index, size = g, i, len(w.word)
+			}
+		}
+		if g == cur && group != nil {
+			break
+		}
+	}
+	return group, index, size
+}
+
+func isSubset(a, b *big.Int) bool {
+	return new(big.Int).AndNot(a, b).Sign() == 0
+}
+
+func catCount(cats *big.Int) int {
+	n := 0
+	for _, w := range cats.Bits() {
+		n += bits.OnesCount(uint(w))
+	}
+	return n
+}
@@ -0,0 +1,189 @@
+package selinux
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSetrans = `
+# Exact translations.
+s0=SystemLow
+s15:c0.c1023=SystemHigh
+s0-s15:c0.c1023=SystemLow-SystemHigh
+
+Base=Sensitivity Levels
+s1=Unclassified
+s2=Confidential
+s3:c0,c2=Secret
+
+ModifierGroup=Releasability
+Whitespace=,
+Join=,
+Prefix=REL TO
+c200=USA
+c201=GBR
+c202=CAN
+c200.c202=FVEY
+Default=c200
+
+ModifierGroup=Compartments
+Whitespace= /
+Join=/
+c10=Alpha
+c11=Bravo
+`
+
+func newTestTranslator(t *testing.T, conf string) *Translator {
+	t.Helper()
+	tr, err := NewTranslator(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+func TestTranslator(t *testing.T) {
+	tr := newTestTranslator(t, testSetrans)
+
+	for _, tc := range []struct {
+		raw, trans string
+	}{
+		{"s0", "SystemLow"},
+		{"s15:c0.c1023", "SystemHigh"},
+		{"s0-s15:c0.c1023", "SystemLow-SystemHigh"},
+		{"s1:c200", "Unclassified"},
+		{"s2:c200,c201", "Confidential REL TO USA,GBR"},
+		{"s2:c200.c202", "Confidential REL TO FVEY"},
+		{"s3:c0,c2,c10,c11,c200", "Secret Alpha/Bravo"},
+		{"s1:c200-s3:c0,c2,c200,c202", "Unclassified-Secret REL TO USA,CAN"},
+		// No translation.
+		{"s1:c5", "s1:c5"},
+		{"s9", "s9"},
+		{"s2", "s2"}, // Releasability can not be empty.
+	} {
+		got, err := tr.RawToTrans(tc.raw)
+		if err != nil || got != tc.trans {
+			t.Errorf("RawToTrans(%q): want %q, got %q (err: %v)", tc.raw, tc.trans, got, err)
+		}
+		got, err = tr.TransToRaw(tc.trans)
+		if err != nil || got != tc.raw {
+			t.Errorf("TransToRaw(%q): want %q, got %q (err: %v)", tc.trans, tc.raw, got, err)
+		}
+	}
+
+	// Translations are read leniently.
+	for trans, raw := range map[string]string{
+		"Confidential REL TO GBR, USA":    "s2:c200,c201",
+		"Secret Bravo Alpha":              "s3:c0,c2,c10,c11,c200",
+		"s2:c200,c201":                    "s2:c200,c201",
+		"  SystemLow - Confidential  ":    "s0-s2:c200",
+		"Confidential REL TO USA / Alpha": "s2:c10,c200",
+	} {
+		got, err := tr.TransToRaw(trans)
+		if err != nil || got != raw {
+			t.Errorf("TransToRaw(%q): want %q, got %q (err: %v)", trans, raw, got, err)
+		}
+	}
+
+	for _, bad := range []string{"TopSecret", "Confidential REL TO AUS", ""} {
+		if got, err := tr.TransToRaw(bad); err == nil {
+			t.Errorf("TransToRaw(%q): want error, got %q", bad, got)
+		}
+	}
+	if _, err := tr.RawToTrans("bad"); err == nil {
+		t.Error("RawToTrans(bad): want error, got nil")
+	}
+}
+
+func TestTranslatorInclude(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.conf"), []byte("s0:c1=Accounting\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tr := newTestTranslator(t, "s0=SystemLow\nInclude="+filepath.Join(dir, "*.conf")+"\n")
+	if got, _ := tr.RawToTrans("s0:c1"); got != "Accounting" {
+		t.Errorf("want Accounting, got %q", got)
+	}
+
+	// Include loops are detected.
+	loop := filepath.Join(dir, "loop.conf")
+	if err := os.WriteFile(loop, []byte("Include="+loop+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewTranslator(strings.NewReader("Include=" + loop)); err == nil {
+		t.Error("want error for include loop, got nil")
+	}
+
+	for _, bad := range []string{"s0", "Join=,", "s0=", "bad=Bad", "ModifierGroup=X\ncx=Bad"} {
+		if _, err := NewTranslator(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: want error, got nil", bad)
+		}
+	}
+}
+
+// testSetransMLS is in the style of the MLS examples shipped with
+// mcstrans, with inverse releasability categories.
+const testSetransMLS = `
+Domain=NATOEXAMPLE
+
+s0=SystemLow
+s15:c0.c1023=SystemHigh
+s0-s15:c0.c1023=SystemLow-SystemHigh
+
+Base=Sensitivity Levels
+s1=UNCLASSIFIED
+s3:c0,c2=RESTRICTED
+s5:c0,c2=SECRET
+
+ModifierGroup=Releasability
+Whitespace=,
+Join=,
+Prefix=REL TO
+Prefix=RELEASABLE TO
+Default=~c200.c511
+~c200=USA
+~c201=GBR
+~c202=CAN
+~c200.c202=FVEY
+
+ModifierGroup=Compartments
+Whitespace= /
+Join=/
+c10=Alpha
+`
+
+func TestTranslatorInverse(t *testing.T) {
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "nato.conf")
+	if err := os.WriteFile(conf, []byte(testSetransMLS), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tr := newTestTranslator(t, "Include="+conf+"\n")
+
+	for _, tc := range []struct {
+		raw, trans string
+	}{
+		{"s5:c0,c2", "SECRET"},
+		{"s5:c0,c2,c201.c511", "SECRET REL TO USA"},
+		{"s5:c0,c2,c202.c511", "SECRET REL TO USA,GBR"},
+		{"s3:c0,c2,c10,c203.c511", "RESTRICTED REL TO FVEY Alpha"},
+		// No translation, as no words release to all but c203.
+		{"s5:c0,c2,c203", "s5:c0,c2,c203"},
+	} {
+		got, err := tr.RawToTrans(tc.raw)
+		if err != nil || got != tc.trans {
+			t.Errorf("RawToTrans(%q): want %q, got %q (err: %v)", tc.raw, tc.trans, got, err)
+		}
+		got, err = tr.TransToRaw(tc.trans)
+		if err != nil || got != tc.raw {
+			t.Errorf("TransToRaw(%q): want %q, got %q (err: %v)", tc.trans, tc.raw, got, err)
+		}
+	}
+
+	// Every prefix is read.
+	if got, err := tr.TransToRaw("SECRET RELEASABLE TO CAN"); err != nil || got != "s5:c0,c2,c200,c201,c203.c511" {
+		t.Errorf("want s5:c0,c2,c200,c201,c203.c511, got %q (err: %v)", got, err)
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package selinux
+
+import "io"
+
+func loadTranslator() (*Translator, error) {
+	return &Translator{}, nil
+}
+
+func (t *Translator) parse(io.Reader, int) error {
+	return nil
+}
+
+func (t *Translator) rawToTrans(raw string) (string, error) {
+	return raw, nil
+}
+
+func (t *Translator) transToRaw(trans string) (string, error) {
+	return trans, nil
+}
@@ -0,0 +1,84 @@
+package selinux
+
+import (
+	"archive/tar"
+	"fmt"
+)
+
+// paxLabelKey is the PAX record holding the SELinux label of a file, as
+// written by GNU tar and others.
+const paxLabelKey = "SCHILY.xattr.security.selinux"
+
+// LabelRemapFunc returns the label to use in place of label. It is called
+// with an empty label for files which have none, and may return an empty
+// label to leave the file with the default one.
+type LabelRemapFunc func(label string) (string, error)
+
+// RemapLabelFixed returns a LabelRemapFunc replacing every label,
+// including an empty one, with label.
+func RemapLabelFixed(label string) LabelRemapFunc {
+	return func(string) (string, error) {
+		return label, nil
+	}
+}
+
+// RemapLabelLevel returns a LabelRemapFunc replacing the MLS/MCS level of
+// every non-empty label with the one of levelFrom, such as the mount label
+// of the target container. See also [CopyLevel].
+func RemapLabelLevel(levelFrom string) LabelRemapFunc {
+	return func(label string) (string, error) {
+		if label == "" {
+			return "", nil
+		}
+		return replaceLevel(label, levelFrom)
+	}
+}
+
+// TarHeaderLabel returns the SELinux label stored in hdr, or an empty
+// string if there is none.
+func TarHeaderLabel(hdr *tar.Header) string {
+	if l, ok := hdr.PAXRecords[paxLabelKey]; ok {
+		return l
+	}
+	// Headers created by hand may still use the deprecated field.
+	return hdr.Xattrs["security.selinux"] //nolint:staticcheck // Xattrs is deprecated, but may be set.
+}
+
+// SetTarHeaderLabel stores the SELinux label of fpath, not following
+// symlinks, in the PAX records of hdr, so it is preserved in the archive.
+// Nothing is stored if fpath has no label.
+func SetTarHeaderLabel(hdr *tar.Header, fpath string) error {
+	label, err := LfileLabel(fpath)
+	if err != nil {
+		if isNoLabel(err) {
+			return nil
+		}
+		return err
+	}
+	if label == "" {
+		return nil
+	}
+	if hdr.PAXRecords == nil {
+		hdr.PAXRecords = make(map[string]string)
+	}
+	hdr.PAXRecords[paxLabelKey] = label
+	return nil
+}
+
+// ApplyTarHeaderLabel sets the SELinux label stored in hdr on fpath, the
+// file extracted from the archive, not following symlinks. If remap is not
+// nil, the label it returns is set instead. Nothing is done if the label
+// to set is empty.
+func ApplyTarHeaderLabel(hdr *tar.Header, fpath string, remap LabelRemapFunc) error {
+	label := TarHeaderLabel(hdr)
+	if remap != nil {
+		var err error
+		if label, err = remap(label); err != nil {
+			return fmt.Errorf("remap label of %s: %w", hdr.Name, err)
+		}
+	}
+	if label == "" {
+		return nil
+	}
+	return LsetFileLabel(fpath, label)
+}
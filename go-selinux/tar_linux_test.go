@@ -0,0 +1,88 @@
+package selinux
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTarHeaderLabel(t *testing.T) {
+	const con = "system_u:object_r:container_file_t:s0:c1,c2"
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	hdr := &tar.Header{
+		Name:       "file",
+		Mode:       0o644,
+		Typeflag:   tar.TypeReg,
+		PAXRecords: map[string]string{paxLabelKey: con},
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	hdr, err := tar.NewReader(&buf).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := TarHeaderLabel(hdr); l != con {
+		t.Errorf("want label %q, got %q", con, l)
+	}
+	if l := TarHeaderLabel(&tar.Header{}); l != "" {
+		t.Errorf("want no label, got %q", l)
+	}
+
+	for _, tc := range []struct {
+		remap     LabelRemapFunc
+		in, out   string
+		expectErr bool
+	}{
+		{remap: RemapLabelFixed("u:r:t:s0"), in: con, out: "u:r:t:s0"},
+		{remap: RemapLabelFixed("u:r:t:s0"), in: "", out: "u:r:t:s0"},
+		{remap: RemapLabelLevel("u:r:t:s0:c3,c4"), in: con, out: "system_u:object_r:container_file_t:s0:c3,c4"},
+		{remap: RemapLabelLevel("u:r:t"), in: con, out: "system_u:object_r:container_file_t"},
+		{remap: RemapLabelLevel("u:r:t:s0:c3,c4"), in: "", out: ""},
+		{remap: RemapLabelLevel("u:r:t:s0:c3,c4"), in: "bad", expectErr: true},
+	} {
+		out, err := tc.remap(tc.in)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("remap(%q): want error, got %q", tc.in, out)
+			}
+			continue
+		}
+		if err != nil || out != tc.out {
+			t.Errorf("remap(%q): want %q, got %q (err: %v)", tc.in, tc.out, out, err)
+		}
+	}
+
+	if !GetEnabled() {
+		return
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	for _, p := range []string{src, dst} {
+		if err := os.WriteFile(p, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SetFileLabel(src, con); err != nil {
+		t.Fatal(err)
+	}
+	hdr = &tar.Header{Name: "src"}
+	if err := SetTarHeaderLabel(hdr, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyTarHeaderLabel(hdr, dst, RemapLabelLevel("u:r:t:s0:c3,c4")); err != nil {
+		t.Fatal(err)
+	}
+	const want = "system_u:object_r:container_file_t:s0:c3,c4"
+	if l, _ := LfileLabel(dst); l != want {
+		t.Errorf("want label %q, got %q", want, l)
+	}
+}
@@ -0,0 +1,426 @@
+package selinux
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/opencontainers/selinux/pkg/pwalkdir"
+)
+
+// LabelMatcher checks the label of the file object at fpath, of the given
+// mode type (see fs.FileMode.Type), against the one it is expected to
+// have. An empty label means the file has none. It returns whether the
+// label is as expected, and the expected label, which is only used for
+// reporting.
+type LabelMatcher func(fpath string, mode fs.FileMode, label string) (ok bool, expected string, err error)
+
+// MatchLabel returns a LabelMatcher expecting every file to have label.
+func MatchLabel(label string) LabelMatcher {
+	return func(_ string, _ fs.FileMode, l string) (bool, string, error) {
+		return l == label, label, nil
+	}
+}
+
+// MatchLevel returns a LabelMatcher expecting every file to have a label
+// with the given MLS/MCS level, regardless of its user, role and type.
+func MatchLevel(level string) (LabelMatcher, error) {
+	want, err := normalizeLevel(level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid level %q: %w", level, err)
+	}
+	return func(_ string, _ fs.FileMode, l string) (bool, string, error) {
+		if l == "" {
+			return false, "*:" + want, nil
+		}
+		con, cErr := NewContext(l)
+		if cErr != nil {
+			return false, "", cErr
+		}
+		if got, lErr := normalizeLevel(con["level"]); lErr == nil && got == want {
+			return true, l, nil
+		}
+		con["level"] = want
+		return false, con.Get(), nil
+	}, nil
+}
+
+// fileContextsSpec is a single entry of a file_contexts file.
+type fileContextsSpec struct {
+	re    *regexp.Regexp
+	mode  fs.FileMode
+	label string // Empty for <<none>>.
+	exact bool   // The path has no regular expression metacharacters.
+	all   bool   // The entry applies to all file types.
+}
+
+// fileContextsModes maps file_contexts file type options to file modes.
+var fileContextsModes = map[string]fs.FileMode{
+	"--": 0,
+	"-d": fs.ModeDir,
+	"-l": fs.ModeSymlink,
+	"-c": fs.ModeDevice | fs.ModeCharDevice,
+	"-b": fs.ModeDevice,
+	"-s": fs.ModeSocket,
+	"-p": fs.ModeNamedPipe,
+}
+
+// FileContextsMatcher returns a LabelMatcher expecting files to have the
+// labels specified in the policy file_contexts files read from rs, which
+// are concatenated in the given order.
+//
+// As in libselinux, an entry for an exact path takes precedence over
+// regular expressions, and otherwise the last matching entry wins. As
+// restorecon does by default, only the types of the labels are compared.
+// Files for which there is no matching entry, or whose entry is <<none>>,
+// are considered to be labeled as expected.
+func FileContextsMatcher(rs ...io.Reader) (LabelMatcher, error) {
+	var specs []fileContextsSpec
+	for _, r := range rs {
+		s, err := parseFileContexts(r)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, s...)
+	}
+	// Exact entries go last, as the search is done backwards.
+	slices.SortStableFunc(specs, func(a, b fileContextsSpec) int {
+		switch {
+		case a.exact == b.exact:
+			return 0
+		case b.exact:
+			return -1
+		}
+		return 1
+	})
+
+	return func(fpath string, mode fs.FileMode, l string) (bool, string, error) {
+		mode = mode.Type()
+		for i := len(specs) - 1; i >= 0; i-- {
+			s := &specs[i]
+			if (!s.all && s.mode != mode) || !s.re.MatchString(fpath) {
+				continue
+			}
+			if s.label == "" {
+				return true, "", nil
+			}
+			if l == "" {
+				return false, s.label, nil
+			}
+			got, err := NewContext(l)
+			if err != nil {
+				return false, s.label, err
+			}
+			want, err := NewContext(s.label)
+			if err != nil {
+				return false, s.label, err
+			}
+			return got["type"] == want["type"], s.label, nil
+		}
+		return true, "", nil
+	}, nil
+}
+
+// PolicyFileContextsMatcher returns a [FileContextsMatcher] for the
+// file_contexts files of the loaded policy, including the home directory
+// entries and local customizations. As in libselinux, paths are first
+// rewritten using the file_contexts.subs and file_contexts.subs_dist path
+// equivalences, see [SubstMatcher].
+func PolicyFileContextsMatcher() (LabelMatcher, error) {
+	fcs, subs := policyFileContexts()
+	var rs []io.Reader
+	for _, p := range fcs {
+		f, err := os.Open(p)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) && len(rs) > 0 {
+				// Only the main file_contexts file is required.
+				continue
+			}
+			return nil, err
+		}
+		defer f.Close()
+		rs = append(rs, f)
+	}
+	m, err := FileContextsMatcher(rs...)
+	if err != nil {
+		return nil, err
+	}
+	var subsRs []io.Reader
+	for _, p := range subs {
+		f, err := os.Open(p)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		defer f.Close()
+		subsRs = append(subsRs, f)
+	}
+	return SubstMatcher(m, subsRs...)
+}
+
+// pathSubst is a single entry of a file_contexts.subs file.
+type pathSubst struct {
+	src, dst string
+}
+
+// SubstMatcher returns a LabelMatcher which rewrites the path of a file
+// using the path equivalences read from rs, in the file_contexts.subs
+// format, before passing it to m. Every line has a source and destination
+// path; a path equal to, or under, the source is matched as if it was
+// under the destination instead.
+//
+// As in libselinux, every reader is a separate list of equivalences,
+// applied in the given order to the result of the previous one, and in
+// each list, the last matching entry wins.
+func SubstMatcher(m LabelMatcher, rs ...io.Reader) (LabelMatcher, error) {
+	var lists [][]pathSubst
+	for _, r := range rs {
+		l, err := parseSubsts(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(l) > 0 {
+			lists = append(lists, l)
+		}
+	}
+	if len(lists) == 0 {
+		return m, nil
+	}
+	return func(fpath string, mode fs.FileMode, l string) (bool, string, error) {
+		for _, list := range lists {
+			fpath = substPath(list, fpath)
+		}
+		return m(fpath, mode, l)
+	}, nil
+}
+
+// substPath returns fpath rewritten by the last matching entry of list.
+func substPath(list []pathSubst, fpath string) string {
+	for i := len(list) - 1; i >= 0; i-- {
+		s := &list[i]
+		rest, ok := strings.CutPrefix(fpath, s.src)
+		if !ok || (rest != "" && rest[0] != '/') {
+			continue
+		}
+		if s.dst == "/" && rest != "" {
+			return rest
+		}
+		return s.dst + rest
+	}
+	return fpath
+}
+
+// parseSubsts parses the file_contexts.subs file from r.
+func parseSubsts(r io.Reader) ([]pathSubst, error) {
+	var list []pathSubst
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("file_contexts.subs line %d: unexpected number of fields", n)
+		}
+		// A trailing slash would never match, as the path is
+		// compared up to a slash.
+		src := strings.TrimRight(fields[0], "/")
+		if src == "" {
+			return nil, fmt.Errorf("file_contexts.subs line %d: invalid source %q", n, fields[0])
+		}
+		list = append(list, pathSubst{src: src, dst: fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read file_contexts.subs: %w", err)
+	}
+	return list, nil
+}
+
+// parseFileContexts parses the file_contexts file from r.
+func parseFileContexts(r io.Reader) ([]fileContextsSpec, error) {
+	var specs []fileContextsSpec
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		s := fileContextsSpec{all: true}
+		switch len(fields) {
+		case 2:
+		case 3:
+			mode, ok := fileContextsModes[fields[1]]
+			if !ok {
+				return nil, fmt.Errorf("file_contexts line %d: unknown file type %q", n, fields[1])
+			}
+			s.mode, s.all = mode, false
+		default:
+			return nil, fmt.Errorf("file_contexts line %d: unexpected number of fields", n)
+		}
+		re, err := regexp.Compile("^(?:" + fields[0] + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("file_contexts line %d: %w", n, err)
+		}
+		s.re = re
+		s.exact = regexp.QuoteMeta(fields[0]) == fields[0]
+		if label := fields[len(fields)-1]; label != "<<none>>" {
+			s.label = label
+		}
+		specs = append(specs, s)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read file_contexts: %w", err)
+	}
+	return specs, nil
+}
+
+// VerifyProblem is the kind of a problem found by [Verify].
+type VerifyProblem int
+
+const (
+	// VerifyMismatch means the file has an unexpected label.
+	VerifyMismatch VerifyProblem = iota + 1
+	// VerifyMissing means the file has no label.
+	VerifyMissing
+	// VerifyUnreadable means the file, or its label, can not be read.
+	VerifyUnreadable
+)
+
+func (p VerifyProblem) String() string {
+	switch p {
+	case VerifyMismatch:
+		return "mismatch"
+	case VerifyMissing:
+		return "missing"
+	case VerifyUnreadable:
+		return "unreadable"
+	}
+	return fmt.Sprintf("VerifyProblem(%d)", int(p))
+}
+
+// VerifyEntry describes a file with a problem found by [Verify].
+type VerifyEntry struct {
+	Path     string
+	Problem  VerifyProblem
+	Expected string // The expected label, if known.
+	Actual   string // The actual label, if any.
+	Err      error  // Set for VerifyUnreadable.
+}
+
+// VerifyReport is the result of [Verify].
+type VerifyReport struct {
+	// Entries are the files with problems, sorted by path.
+	Entries []VerifyEntry
+
+	// Checked is the number of files whose labels were checked, and
+	// Matched is the number of those labeled as expected.
+	Checked, Matched int
+	// Mismatched, Missing and Unreadable are the numbers of entries
+	// with the respective problems.
+	Mismatched, Missing, Unreadable int
+}
+
+// OK reports whether no problems were found.
+func (r *VerifyReport) OK() bool {
+	return len(r.Entries) == 0
+}
+
+// String returns a one-line summary of r.
+func (r *VerifyReport) String() string {
+	return fmt.Sprintf("%d checked, %d matched, %d mismatched, %d missing, %d unreadable",
+		r.Checked, r.Matched, r.Mismatched, r.Missing, r.Unreadable)
+}
+
+// add records the entry e.
+func (r *VerifyReport) add(e VerifyEntry) {
+	r.Entries = append(r.Entries, e)
+	switch e.Problem {
+	case VerifyMismatch:
+		r.Mismatched++
+	case VerifyMissing:
+		r.Missing++
+	case VerifyUnreadable:
+		r.Unreadable++
+	}
+}
+
+// Verify checks the labels of the file tree rooted at root, not following
+// symlinks, against expected, and returns a report of the files that do
+// not match. Nothing is modified. The tree is walked in parallel, using
+// [pwalkdir.WalkWithOptions]; directories that can not be read are
+// reported, and the walk continues.
+//
+// A relative root is made absolute first, so that the paths passed to
+// expected, and reported, are absolute, as the ones in file_contexts.
+//
+// An error is returned if root can not be accessed, or expected returns
+// an error, in which case the walk is stopped.
+func Verify(root string, expected LabelMatcher) (*VerifyReport, error) {
+	if expected == nil {
+		return nil, errors.New("verify: matcher is nil")
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Lstat(root); err != nil {
+		return nil, err
+	}
+	var (
+		mu     sync.Mutex
+		report VerifyReport
+	)
+	err = pwalkdir.WalkWithOptions(context.Background(), root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			mu.Lock()
+			report.add(VerifyEntry{Path: p, Problem: VerifyUnreadable, Err: err})
+			mu.Unlock()
+			return nil
+		}
+		label, err := LfileLabel(p)
+		if err != nil && !isNoLabel(err) {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			mu.Lock()
+			report.Checked++
+			report.add(VerifyEntry{Path: p, Problem: VerifyUnreadable, Err: err})
+			mu.Unlock()
+			return nil
+		}
+		ok, want, err := expected(p, d.Type(), label)
+		if err != nil {
+			return fmt.Errorf("verify %s: %w", p, err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		report.Checked++
+		switch {
+		case ok:
+			report.Matched++
+		case label == "":
+			report.add(VerifyEntry{Path: p, Problem: VerifyMissing, Expected: want})
+		default:
+			report.add(VerifyEntry{Path: p, Problem: VerifyMismatch, Expected: want, Actual: label})
+		}
+		return nil
+	}, &pwalkdir.Options{ReportErrors: true})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(report.Entries, func(a, b VerifyEntry) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return &report, nil
+}
@@ -0,0 +1,219 @@
+package selinux
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testFileContexts = `
+# Comment.
+/var/lib/containers(/.*)?		system_u:object_r:container_var_lib_t:s0
+/var/lib/containers/storage/overlay(/.*)?	system_u:object_r:container_ro_file_t:s0
+/var/lib/containers/storage/volumes/[^/]*/.*	system_u:object_r:container_file_t:s0
+/var/lib/containers/storage/volumes -d	system_u:object_r:container_var_lib_t:s0
+/var/lib/containers/storage/.*\.lock	--	system_u:object_r:container_lock_t:s0
+/var/lib/containers/none	<<none>>
+`
+
+func TestFileContextsMatcher(t *testing.T) {
+	m, err := FileContextsMatcher(strings.NewReader(testFileContexts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path     string
+		mode     fs.FileMode
+		label    string
+		ok       bool
+		expected string
+	}{
+		{
+			path:     "/var/lib/containers/storage/overlay/abc/diff",
+			mode:     fs.ModeDir,
+			label:    "system_u:object_r:container_ro_file_t:s0:c1,c2",
+			ok:       true,
+			expected: "system_u:object_r:container_ro_file_t:s0",
+		},
+		{
+			path:     "/var/lib/containers/storage/volumes/v/_data",
+			label:    "system_u:object_r:container_ro_file_t:s0",
+			expected: "system_u:object_r:container_file_t:s0",
+		},
+		{
+			// Exact entries take precedence, even if they come first.
+			path:     "/var/lib/containers/storage/volumes",
+			mode:     fs.ModeDir,
+			label:    "system_u:object_r:container_var_lib_t:s0",
+			ok:       true,
+			expected: "system_u:object_r:container_var_lib_t:s0",
+		},
+		{
+			// File type mismatch, so the generic entry applies.
+			path:     "/var/lib/containers/storage/x.lock",
+			mode:     fs.ModeDir,
+			label:    "system_u:object_r:container_lock_t:s0",
+			expected: "system_u:object_r:container_var_lib_t:s0",
+		},
+		{
+			path:     "/var/lib/containers/storage/x.lock",
+			label:    "system_u:object_r:container_lock_t:s0",
+			ok:       true,
+			expected: "system_u:object_r:container_lock_t:s0",
+		},
+		{
+			path:     "/var/lib/containers/storage/x.lock",
+			expected: "system_u:object_r:container_lock_t:s0",
+		},
+		{
+			path: "/var/lib/containers/none",
+			ok:   true,
+		},
+		{
+			path: "/etc/passwd",
+			ok:   true,
+		},
+	}
+	for _, tc := range tests {
+		ok, expected, err := m(tc.path, tc.mode, tc.label)
+		if err != nil {
+			t.Errorf("%s: %v", tc.path, err)
+			continue
+		}
+		if ok != tc.ok || expected != tc.expected {
+			t.Errorf("%s: want (%v, %q), got (%v, %q)", tc.path, tc.ok, tc.expected, ok, expected)
+		}
+	}
+
+	for _, bad := range []string{"/a -x u:r:t:s0", "/a b c d", "/a( u:r:t:s0"} {
+		if _, err := FileContextsMatcher(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: want error, got nil", bad)
+		}
+	}
+}
+
+func TestSubstMatcher(t *testing.T) {
+	fc, err := FileContextsMatcher(strings.NewReader(testFileContexts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const (
+		local = "/data/containers /var/lib/containers\n/srv /\n"
+		dist  = "# Comment.\n/run/containers /var/lib/containers\n/var/lib/containers/old /var/lib/containers/none\n"
+	)
+	m, err := SubstMatcher(fc, strings.NewReader(local), strings.NewReader(dist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const ro = "system_u:object_r:container_ro_file_t:s0"
+	for _, tc := range []struct {
+		path     string
+		expected string
+	}{
+		{"/data/containers/storage/overlay/abc", ro},
+		{"/run/containers/storage/overlay", ro},
+		{"/srv/var/lib/containers/storage/overlay", ro},
+		{"/data/containersx/storage/overlay", ""},
+		// Both lists are applied, local first.
+		{"/data/containers/old", ""},
+		{"/var/lib/containers/storage/overlay", ro},
+	} {
+		_, expected, err := m(tc.path, fs.ModeDir, "")
+		if err != nil {
+			t.Errorf("%s: %v", tc.path, err)
+			continue
+		}
+		if expected != tc.expected {
+			t.Errorf("%s: want %q, got %q", tc.path, tc.expected, expected)
+		}
+	}
+
+	if _, err := SubstMatcher(fc, strings.NewReader("/a /b /c")); err == nil {
+		t.Error("want error for bad substitution")
+	}
+}
+
+func TestMatchLevel(t *testing.T) {
+	m, err := MatchLevel("s0:c1.c2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for label, want := range map[string]bool{
+		"u:r:t:s0:c1,c2": true,
+		"u:r:t:s0:c1":    false,
+		"u:r:t":          false,
+		"":               false,
+	} {
+		if ok, _, err := m("/", 0, label); err != nil || ok != want {
+			t.Errorf("%q: want %v, got %v (err: %v)", label, want, ok, err)
+		}
+	}
+	if _, err := MatchLevel("bad"); err == nil {
+		t.Error("want error for bad level")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{"a", "b"} {
+		if err := os.WriteFile(filepath.Join(dir, p), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const con = "system_u:object_r:container_file_t:s0:c1,c2"
+	if GetEnabled() {
+		if err := Chcon(dir, con, true); err != nil {
+			t.Fatal(err)
+		}
+		if err := SetFileLabel(filepath.Join(dir, "b"), "system_u:object_r:container_file_t:s0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err := Verify(dir, MatchLabel(con))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(report)
+	if report.Checked != 3 {
+		t.Errorf("want 3 files checked, got %d", report.Checked)
+	}
+	if !GetEnabled() {
+		// Unless SELinux is enabled, files have no labels.
+		if report.Missing != 3 || len(report.Entries) != 3 || report.Entries[0].Path != dir {
+			t.Errorf("want 3 missing labels, got %+v", report.Entries)
+		}
+		return
+	}
+	if report.Matched != 2 || len(report.Entries) != 1 {
+		t.Fatalf("want 1 mismatch, got %+v", report.Entries)
+	}
+	e := report.Entries[0]
+	if e.Path != filepath.Join(dir, "b") || e.Problem != VerifyMismatch || e.Expected != con || e.Actual != "system_u:object_r:container_file_t:s0" {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+}
+
+func TestVerifyRelative(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(wd, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// File contexts are absolute paths, so the matcher must see them.
+	report, err := Verify(rel, func(p string, _ fs.FileMode, _ string) (bool, string, error) {
+		return p == dir, "", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Matched != 1 || len(report.Entries) != 0 {
+		t.Errorf("want %s matched, got %+v", dir, report)
+	}
+}
@@ -0,0 +1,189 @@
+package selinux
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/opencontainers/selinux/pkg/pwalkdir"
+)
+
+// fdWalkFunc is called by fdWalk for every file object in a tree. fd
+// refers to the object at fpath, and is only valid during the call. For
+// directories, fd is a regular read-only descriptor; for anything else,
+// it is an O_PATH descriptor.
+type fdWalkFunc func(fpath string, fd int, isDir bool) error
+
+// openat2Unsupported is set once openat2(2) is found to be unavailable.
+var openat2Unsupported atomic.Bool
+
+// openBeneath opens name, which must be a single path component, relative
+// to the directory dirfd, without following symlinks. If the final
+// component is a symlink and flags include O_PATH, the returned
+// descriptor refers to the symlink itself.
+func openBeneath(dirfd int, name string, flags int) (int, error) {
+	flags |= unix.O_NOFOLLOW | unix.O_CLOEXEC
+	if !openat2Unsupported.Load() {
+		fd, err := unix.Openat2(dirfd, name, &unix.OpenHow{
+			Flags:   uint64(flags), //#nosec G115 -- flags are non-negative.
+			Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_SYMLINKS | unix.RESOLVE_NO_MAGICLINKS,
+		})
+		if !errors.Is(err, unix.ENOSYS) {
+			return fd, err
+		}
+		openat2Unsupported.Store(true)
+	}
+	// For a single path component, O_NOFOLLOW gives the same guarantees.
+	return unix.Openat(dirfd, name, flags, 0)
+}
+
+// openPathBeneath opens the relative path rel beneath the directory dirfd
+// with O_PATH, one component at a time using openBeneath, so that no
+// symlink is followed. If the final component is a symlink, the returned
+// descriptor refers to the symlink itself. A symlink in place of any
+// other component results in ENOTDIR or ELOOP.
+func openPathBeneath(dirfd int, rel string) (int, error) {
+	comps := strings.Split(filepath.Clean(rel), "/")
+	fd := dirfd
+	for i, c := range comps {
+		flags := unix.O_PATH
+		if i < len(comps)-1 {
+			flags |= unix.O_DIRECTORY
+		}
+		nfd, err := openBeneath(fd, c, flags)
+		if fd != dirfd {
+			unix.Close(fd)
+		}
+		if err != nil {
+			return -1, err
+		}
+		fd = nfd
+	}
+	return fd, nil
+}
+
+// openEntry opens the entry name in the directory dirfd. Directories are
+// opened for reading, anything else with O_PATH. The type is determined
+// from the opened descriptor, so it can not be raced against.
+func openEntry(dirfd int, name string, isDir bool) (int, bool, error) {
+	if isDir {
+		fd, err := openBeneath(dirfd, name, unix.O_RDONLY|unix.O_DIRECTORY)
+		// The entry may have been replaced by a non-directory.
+		if !errors.Is(err, unix.ENOTDIR) && !errors.Is(err, unix.ELOOP) {
+			return fd, true, err
+		}
+	}
+	fd, err := openBeneath(dirfd, name, unix.O_PATH)
+	if err != nil {
+		return -1, false, err
+	}
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		unix.Close(fd)
+		return -1, false, err
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		return fd, false, nil
+	}
+	// The entry may have been replaced by a directory. Reopening "."
+	// relative to it is race-free.
+	dfd, err := unix.Openat(fd, ".", unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	unix.Close(fd)
+	return dfd, true, err
+}
+
+// openDirBeneath is the pwalkdir.Options.OpenDir function opening name
+// in dir without following symlinks, or, if dir is nil, the root path
+// name, not following a symlink in its final component.
+func openDirBeneath(dir *os.File, name string) (*os.File, error) {
+	var (
+		fd  int
+		err error
+	)
+	if dir == nil {
+		fd, err = unix.Open(name, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	} else {
+		fd, err = openBeneath(int(dir.Fd()), name, unix.O_RDONLY|unix.O_DIRECTORY) //#nosec G115 -- fd fits into int.
+		name = filepath.Join(dir.Name(), name)
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "openat", Path: name, Err: err}
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+// fdWalk walks the file tree rooted at root, calling walkFn for each
+// file object in the tree, including root, using pwalkdir.WalkAt.
+//
+// Unlike path-based walkers, every entry is opened relative to its
+// parent directory descriptor, never following symlinks, and walkFn
+// operates on the resulting descriptor. Thus, if a directory in the tree
+// is concurrently replaced by a symlink, fdWalk does not follow it out of
+// the tree. Root itself is opened by path, not following a symlink in its
+// final component.
+//
+// Every directory is processed after its contents (see
+// pwalkdir.Options.PostOrder), so root is processed last. Entries removed
+// during the walk are skipped. Once a walkFn returns an error, no more
+// calls are made, and the error is returned.
+//
+// As each directory is kept open until it is processed, fdWalk uses a
+// file descriptor for every level of the tree being walked.
+func fdWalk(root string, walkFn fdWalkFunc) error {
+	return pwalkdir.WalkAt(context.Background(), root, func(dir *os.File, p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// The directory may have been replaced by a non-directory
+			// or a symlink after it was read. If so, it is processed
+			// as such by the final call for it.
+			if errors.Is(err, unix.ENOTDIR) || errors.Is(err, unix.ELOOP) {
+				return nil
+			}
+			return err
+		}
+		var (
+			fd    int
+			isDir bool
+		)
+		if dir == nil {
+			fd, isDir, err = openRoot(p)
+		} else {
+			fd, isDir, err = openEntry(int(dir.Fd()), d.Name(), d.IsDir()) //#nosec G115 -- fd fits into int.
+		}
+		if err != nil {
+			// Walking a file tree can race with removal,
+			// so ignore ENOENT, except for root.
+			if dir != nil && errors.Is(err, unix.ENOENT) {
+				return nil
+			}
+			return &os.PathError{Op: "openat", Path: p, Err: err}
+		}
+		defer unix.Close(fd)
+		return walkFn(p, fd, isDir)
+	}, &pwalkdir.Options{ReportErrors: true, PostOrder: true, OpenDir: openDirBeneath})
+}
+
+// openRoot is like openEntry, but opens root by path, not following a
+// symlink in its final component.
+func openRoot(root string) (int, bool, error) {
+	fd, err := unix.Open(root, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, false, err
+	}
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		unix.Close(fd)
+		return -1, false, err
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		return fd, false, nil
+	}
+	dfd, err := unix.Openat(fd, ".", unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	unix.Close(fd)
+	return dfd, true, err
+}
@@ -0,0 +1,145 @@
+package selinux
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestFdWalk(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	want := map[string]bool{root: true} // path -> isDir
+	for _, d := range []string{"a", "a/b", "a/b/c", "d"} {
+		p := filepath.Join(root, d)
+		if err := os.Mkdir(p, 0o700); err != nil {
+			t.Fatal(err)
+		}
+		want[p] = true
+	}
+	for _, f := range []string{"f", "a/f", "a/b/f", "a/b/c/f", "d/f"} {
+		p := filepath.Join(root, f)
+		if err := os.WriteFile(p, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		want[p] = false
+	}
+	link := filepath.Join(root, "a", "link")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Fatal(err)
+	}
+	want[link] = false
+
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu   sync.Mutex
+		seen = map[string]bool{}
+	)
+	err = fdWalk(root, func(p string, _ int, isDir bool) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := seen[p]; ok {
+			t.Errorf("%s visited twice", p)
+		}
+		if isDir {
+			entries, err := os.ReadDir(p)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if _, ok := seen[filepath.Join(p, e.Name())]; !ok {
+					t.Errorf("%s visited before its entry %s", p, e.Name())
+				}
+			}
+		}
+		seen[p] = isDir
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != len(want) {
+		t.Errorf("want %d entries, got %d: %v", len(want), len(seen), seen)
+	}
+	for p, isDir := range want {
+		if got, ok := seen[p]; !ok || got != isDir {
+			t.Errorf("%s: want visited with isDir=%v, got visited=%v isDir=%v", p, isDir, ok, got)
+		}
+	}
+
+	fds2, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fds2) != len(fds) {
+		t.Errorf("file descriptors leaked: had %d, have %d", len(fds), len(fds2))
+	}
+
+	// An error stops the walk and is returned.
+	e42 := errors.New("42")
+	if err := fdWalk(root, func(string, int, bool) error { return e42 }); !errors.Is(err, e42) {
+		t.Errorf("want %v, got %v", e42, err)
+	}
+
+	// A symlink as root is not followed.
+	err = fdWalk(link, func(p string, _ int, isDir bool) error {
+		if p != link || isDir {
+			t.Errorf("unexpected entry %s (isDir=%v)", p, isDir)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenPathBeneath(t *testing.T) {
+	outside := t.TempDir()
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "a", "b"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "b", "f"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "a", "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "f"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rootFd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(rootFd)
+
+	for _, rel := range []string{".", "a", "a/b/f", "a/link"} {
+		fd, err := openPathBeneath(rootFd, rel)
+		if err != nil {
+			t.Errorf("%s: %v", rel, err)
+			continue
+		}
+		unix.Close(fd)
+	}
+	// A symlink in place of a directory is not followed.
+	if _, err := openPathBeneath(rootFd, "a/link/f"); !errors.Is(err, unix.ENOTDIR) && !errors.Is(err, unix.ELOOP) {
+		t.Errorf("a/link/f: want ENOTDIR or ELOOP, got %v", err)
+	}
+	if _, err := openPathBeneath(rootFd, "a/none"); !errors.Is(err, unix.ENOENT) {
+		t.Errorf("a/none: want ENOENT, got %v", err)
+	}
+}
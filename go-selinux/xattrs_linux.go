@@ -7,18 +7,43 @@ import (
 // lgetxattr returns a []byte slice containing the value of
 // an extended attribute attr set for path.
 func lgetxattr(path, attr string) ([]byte, error) {
+	return getxattrAll(func(dest []byte) (int, error) {
+		return unix.Lgetxattr(path, attr, dest)
+	})
+}
+
+// getxattr returns a []byte slice containing the value of
+// an extended attribute attr set for path.
+func getxattr(path, attr string) ([]byte, error) {
+	return getxattrAll(func(dest []byte) (int, error) {
+		return unix.Getxattr(path, attr, dest)
+	})
+}
+
+// fgetxattr returns a []byte slice containing the value of
+// an extended attribute attr set for the file descriptor fd.
+func fgetxattr(fd int, attr string) ([]byte, error) {
+	return getxattrAll(func(dest []byte) (int, error) {
+		return unix.Fgetxattr(fd, attr, dest)
+	})
+}
+
+// getxattrAll returns a []byte slice containing the value of an extended
+// attribute, as obtained by get, which is one of getxattr(2) family of
+// syscalls with all arguments except the buffer already bound.
+func getxattrAll(get func(dest []byte) (int, error)) ([]byte, error) {
 	// Start with a 128 length byte array
 	dest := make([]byte, 128)
-	sz, errno := doLgetxattr(path, attr, dest)
+	sz, errno := doGetxattr(get, dest)
 	for errno == unix.ERANGE { //nolint:errorlint // unix errors are bare
 		// Buffer too small, use zero-sized buffer to get the actual size
-		sz, errno = doLgetxattr(path, attr, []byte{})
+		sz, errno = doGetxattr(get, []byte{})
 		if errno != nil {
 			return nil, errno
 		}
 
 		dest = make([]byte, sz)
-		sz, errno = doLgetxattr(path, attr, dest)
+		sz, errno = doGetxattr(get, dest)
 	}
 	if errno != nil {
 		return nil, errno
@@ -27,45 +52,34 @@ func lgetxattr(path, attr string) ([]byte, error) {
 	return dest[:sz], nil
 }
 
-// doLgetxattr is a wrapper that retries on EINTR
-func doLgetxattr(path, attr string, dest []byte) (int, error) {
+// doGetxattr is a wrapper that retries on EINTR
+func doGetxattr(get func(dest []byte) (int, error), dest []byte) (int, error) {
 	for {
-		sz, err := unix.Lgetxattr(path, attr, dest)
+		sz, err := get(dest)
 		if err != unix.EINTR {
 			return sz, err
 		}
 	}
 }
 
-// getxattr returns a []byte slice containing the value of
-// an extended attribute attr set for path.
-func getxattr(path, attr string) ([]byte, error) {
-	// Start with a 128 length byte array
-	dest := make([]byte, 128)
-	sz, errno := dogetxattr(path, attr, dest)
-	for errno == unix.ERANGE { //nolint:errorlint // unix errors are bare
-		// Buffer too small, use zero-sized buffer to get the actual size
-		sz, errno = dogetxattr(path, attr, []byte{})
-		if errno != nil {
-			return nil, errno
+// fsetxattr sets the value of an extended attribute attr for the file
+// descriptor fd, retrying on EINTR.
+func fsetxattr(fd int, attr string, data []byte) error {
+	for {
+		err := unix.Fsetxattr(fd, attr, data, 0)
+		if err != unix.EINTR {
+			return err
 		}
-
-		dest = make([]byte, sz)
-		sz, errno = dogetxattr(path, attr, dest)
-	}
-	if errno != nil {
-		return nil, errno
 	}
-
-	return dest[:sz], nil
 }
 
-// dogetxattr is a wrapper that retries on EINTR
-func dogetxattr(path, attr string, dest []byte) (int, error) {
+// setxattr sets the value of an extended attribute attr for path,
+// retrying on EINTR.
+func setxattr(path, attr string, data []byte) error {
 	for {
-		sz, err := unix.Getxattr(path, attr, dest)
+		err := unix.Setxattr(path, attr, data, 0)
 		if err != unix.EINTR {
-			return sz, err
+			return err
 		}
 	}
 }
@@ -1,20 +1,31 @@
 package pwalkdir
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"os"
 	"path/filepath"
 	"runtime"
 	"sync"
+	"sync/atomic"
 )
 
-// Walk is a wrapper for filepath.WalkDir which can call multiple walkFn
-// in parallel, allowing to handle each item concurrently. A maximum of
-// twice the runtime.NumCPU() walkFn will be called at any one time.
-// If you want to change the maximum, use WalkN instead.
+// readDirBatch is the maximum number of directory entries read at once.
+// It limits the memory used for very wide directories.
+const readDirBatch = 256
+
+// Walk walks the file tree rooted at root, calling walkFn for each file
+// or directory in the tree, including root, similar to filepath.WalkDir.
+// Both reading directories and calling walkFn is done in parallel by
+// twice the runtime.NumCPU() goroutines, so a maximum of that number of
+// walkFn will be called at any one time. If you want to change the
+// maximum, use WalkN instead.
 //
-// The order of calls is non-deterministic.
+// The order of calls is non-deterministic, except the root is guaranteed
+// to be processed last.
 //
 // Note that this implementation only supports primitive error handling:
 //
@@ -23,18 +34,19 @@ import (
 // - once a walkFn returns any error, all further processing stops
 // and the error is returned to the caller of Walk;
 //
-// - filepath.SkipDir is not supported;
+// - if more than one walkFn instance returns an error before the
+// processing stops, all of these errors are returned, joined by
+// errors.Join.
 //
-// - if more than one walkFn instance will return an error, only one
-// of such errors will be propagated and returned by Walk, others
-// will be silently discarded.
+// Walk is a shorthand for WalkWithOptions, so fs.SkipDir and fs.SkipAll
+// returned by walkFn are handled as documented there. Use WalkWithOptions
+// if any of the above is a problem.
 func Walk(root string, walkFn fs.WalkDirFunc) error {
 	return WalkN(root, walkFn, runtime.NumCPU()*2)
 }
 
-// WalkN is a wrapper for filepath.WalkDir which can call multiple walkFn
-// in parallel, allowing to handle each item concurrently. A maximum of
-// num walkFn will be called at any one time.
+// WalkN is like Walk, but uses num goroutines, so a maximum of num
+// walkFn will be called at any one time.
 //
 // Please see Walk documentation for caveats of using this function.
 func WalkN(root string, walkFn fs.WalkDirFunc, num int) error {
@@ -43,78 +55,473 @@ func WalkN(root string, walkFn fs.WalkDirFunc, num int) error {
 		return fmt.Errorf("walk(%q): num must be > 0", root)
 	}
 
-	files := make(chan *walkArgs, 2*num)
-	errCh := make(chan error, 1) // Get the first error, ignore others.
-
-	// Start walking a tree asap.
-	var (
-		err error
-		wg  sync.WaitGroup
-
-		rootLen   = len(root)
-		rootEntry *walkArgs
-	)
-	wg.Add(1)
-	go func() {
-		err = filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
-			if err != nil {
-				// Walking a file tree can race with removal,
-				// so ignore ENOENT, except for root.
-				// https://github.com/opencontainers/selinux/issues/199.
-				if errors.Is(err, fs.ErrNotExist) && len(p) != rootLen {
-					return nil
-				}
-				close(files)
-				return err
-			}
-			if len(p) == rootLen {
-				// Root entry is processed separately below.
-				rootEntry = &walkArgs{path: p, entry: entry}
-				return nil
-			}
-			// Add a file to the queue unless a callback sent an error.
-			select {
-			case e := <-errCh:
-				close(files)
-				return e
-			default:
-				files <- &walkArgs{path: p, entry: entry}
-				return nil
-			}
-		})
-		if err == nil {
-			close(files)
+	return WalkWithOptions(context.Background(), root, walkFn, &Options{Concurrency: num})
+}
+
+// Options are the parameters of WalkWithOptions.
+type Options struct {
+	// Concurrency is the number of goroutines used to read directories
+	// and call walkFn, and thus the maximum number of walkFn to be called
+	// at any one time. If zero, twice the runtime.NumCPU() is used.
+	Concurrency int
+
+	// MaxErrors is the number of errors to collect before the walk is
+	// stopped. If zero, the walk is stopped upon the first error, same
+	// as Walk does. If negative, the walk is never stopped because of
+	// errors, and all of them are collected.
+	MaxErrors int
+
+	// ReportErrors, if set, makes errors from reading a directory to be
+	// passed to walkFn, same as filepath.WalkDir does. walkFn can then
+	// return nil or fs.SkipDir to continue the walk. If not set, such
+	// errors are treated as if walkFn returned them.
+	//
+	// In any case, fs.ErrNotExist errors for any path except root are
+	// ignored, since walking a tree can race with removal.
+	ReportErrors bool
+
+	// PostOrder, if set, makes walkFn for each directory to be called
+	// only after walkFn calls for all of its contents (recursively) have
+	// returned. Calls for unrelated entries still run in parallel.
+	//
+	// Since the contents are processed first, fs.SkipDir returned for a
+	// directory has no effect. If Options.ReportErrors is also set, and
+	// walkFn returns fs.SkipDir for an error reading a directory, the
+	// final call for this directory is not made.
+	PostOrder bool
+
+	// OpenDir, if set, is used to open directories for reading, instead
+	// of os.Open. It is called with the open parent directory and the
+	// name of the directory in it, or, for root, with a nil dir and the
+	// root path. An error is handled as any other error reading the
+	// directory.
+	OpenDir func(dir *os.File, name string) (*os.File, error)
+}
+
+// WalkAtFunc is the type of the function called by WalkAt for each file
+// or directory. It is the same as fs.WalkDirFunc, except that dir is the
+// open parent directory of path, in which d.Name() refers to the entry,
+// or nil for root. dir is only valid until walkFn returns, and must not
+// be closed or read from.
+type WalkAtFunc func(dir *os.File, path string, d fs.DirEntry, err error) error
+
+// WalkWithOptions walks the file tree rooted at root, calling walkFn
+// for each file or directory in the tree, including root. Directories
+// are read, and walkFn is called, by multiple goroutines in parallel.
+//
+// Unlike Walk, it supports the following:
+//
+//   - walkFn can return fs.SkipDir for a directory to skip its contents;
+//
+//   - walkFn can return fs.SkipDir for a non-directory entry to skip the
+//     remaining entries in its parent directory. Since walkFn calls run
+//     in parallel, this is done on a best-effort basis: entries which
+//     are already being processed are not affected;
+//
+//   - walkFn can return fs.SkipAll to stop the walk without an error;
+//
+//   - errors from reading directories can be passed to walkFn
+//     (see Options.ReportErrors);
+//
+//   - errors are collected rather than discarded, and are returned
+//     joined by errors.Join (see Options.MaxErrors);
+//
+//   - the walk can be canceled via ctx, in which case ctx.Err() is
+//     included in the returned errors;
+//
+//   - directories can be processed after their contents
+//     (see Options.PostOrder).
+//
+// As with Walk, the order of calls is non-deterministic, except the root
+// is guaranteed to be processed last. Unless Options.PostOrder is set, a
+// directory's walkFn is called before its contents are read, but may run
+// concurrently with calls for entries in other directories. fs.SkipDir or
+// fs.SkipAll returned for root are ignored. A nil opts is the same as a
+// zero Options.
+func WalkWithOptions(ctx context.Context, root string, walkFn fs.WalkDirFunc, opts *Options) error {
+	return walkAt(ctx, root, func(_ *os.File, p string, d fs.DirEntry, err error) error {
+		return walkFn(p, d, err)
+	}, opts, false)
+}
+
+// WalkAt is like WalkWithOptions, but walkFn is also passed the parent
+// directory of each entry. Along with Options.OpenDir, this allows to
+// walk a tree relative to directory descriptors, rather than by path,
+// e.g. to not be redirected by a directory concurrently replaced with
+// a symlink.
+//
+// As every directory is kept open until walkFn is called for all of its
+// entries, WalkAt uses a file descriptor for every directory read but not
+// yet done with. With Options.PostOrder, this includes all the ancestors
+// of the directories being read, so the number of file descriptors grows
+// with the depth of the tree.
+func WalkAt(ctx context.Context, root string, walkFn WalkAtFunc, opts *Options) error {
+	return walkAt(ctx, root, walkFn, opts, true)
+}
+
+// walkAt implements WalkAt. Unless keepDirs or Options.OpenDir is set,
+// directories are closed as soon as they are read, and walkFn is passed
+// a nil parent directory.
+func walkAt(ctx context.Context, root string, walkFn WalkAtFunc, opts *Options, keepDirs bool) error {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.Concurrency == 0 {
+		o.Concurrency = runtime.NumCPU() * 2
+	}
+	if o.Concurrency < 1 {
+		return fmt.Errorf("walk(%q): concurrency must be > 0", root)
+	}
+	if o.MaxErrors == 0 {
+		o.MaxErrors = 1
+	}
+
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := &walker{
+		ctx:          wctx,
+		cancel:       cancel,
+		walkFn:       walkFn,
+		maxErrors:    o.MaxErrors,
+		reportErrors: o.ReportErrors,
+		postOrder:    o.PostOrder,
+		openDir:      o.OpenDir,
+		keepDirs:     keepDirs || o.OpenDir != nil,
+		queue:        make(chan *walkArgs, 2*o.Concurrency),
+	}
+
+	info, err := os.Lstat(root)
+	if err != nil {
+		if o.ReportErrors {
+			w.handleDir(w.walkFn(nil, root, nil, err))
+		} else {
+			w.addError(err)
 		}
-		wg.Done()
-	}()
+		return w.result(ctx)
+	}
+	rootEntry := &walkArgs{path: root, entry: fs.FileInfoToDirEntry(info), root: true}
 
-	wg.Add(num)
-	for range num {
+	var wg sync.WaitGroup
+	wg.Add(o.Concurrency)
+	for range o.Concurrency {
 		go func() {
-			for file := range files {
-				if e := walkFn(file.path, file.entry, nil); e != nil {
-					select {
-					case errCh <- e: // sent ok
-					default: // buffer full
-					}
-				}
+			defer wg.Done()
+			for t := range w.queue {
+				w.process(t)
+				w.pending.Done()
+				w.drain()
 			}
-			wg.Done()
 		}()
 	}
-
+	if rootEntry.entry.IsDir() {
+		w.submit(rootEntry)
+	}
+	w.pending.Wait()
+	close(w.queue)
 	wg.Wait()
 
-	if err == nil {
-		err = walkFn(rootEntry.path, rootEntry.entry, nil)
+	if !w.stopped() {
+		err := w.walkFn(nil, rootEntry.path, rootEntry.entry, nil)
+		if err != nil && !errors.Is(err, fs.SkipDir) && !errors.Is(err, fs.SkipAll) {
+			w.addError(err)
+		}
 	}
 
-	return err
+	return w.result(ctx)
 }
 
-// walkArgs holds the arguments that were passed to the Walk or WalkN
-// functions.
+// walkArgs holds the arguments that are passed to walkFn.
 type walkArgs struct {
-	entry fs.DirEntry
-	path  string
+	entry  fs.DirEntry
+	dir    *dirHandle // Nil for root.
+	parent *dirNode   // Only used with Options.PostOrder.
+	path   string
+	root   bool
+}
+
+// dirHandle is an open directory, kept open until all the entries read
+// from it are processed. A nil *dirHandle is valid, and has no file.
+type dirHandle struct {
+	file *os.File
+	refs atomic.Int64
+}
+
+// acquire adds a reference to h, and returns it.
+func (h *dirHandle) acquire() *dirHandle {
+	if h != nil {
+		h.refs.Add(1)
+	}
+	return h
+}
+
+// release drops a reference to h, closing it once there are none left.
+// It is a no-op for a nil h.
+func (h *dirHandle) release() {
+	if h != nil && h.refs.Add(-1) == 0 {
+		h.file.Close()
+	}
+}
+
+// get returns the file of h, or nil for a nil h.
+func (h *dirHandle) get() *os.File {
+	if h == nil {
+		return nil
+	}
+	return h.file
+}
+
+// dirNode tracks a directory being walked in post-order.
+type dirNode struct {
+	args   *walkArgs
+	parent *dirNode
+	// pending is the number of entries in the directory which are not
+	// yet processed, plus one while the directory is being read.
+	pending atomic.Int64
+	// skip is set if walkFn should not be called for the directory.
+	skip atomic.Bool
+}
+
+// done is called when an entry of the directory n is processed. Once all
+// the entries are, it calls walkFn for the directory itself, and in turn
+// notifies its parent. It is a no-op for a nil n.
+func (n *dirNode) done(w *walker) {
+	for ; n != nil; n = n.parent {
+		if n.pending.Add(-1) != 0 {
+			return
+		}
+		// Root is processed separately by WalkAt.
+		if !n.args.root && !n.skip.Load() && !w.stopped() {
+			w.handleDir(w.walkFn(n.args.dir.get(), n.args.path, n.args.entry, nil))
+		}
+		n.args.dir.release()
+	}
+}
+
+// walker is the state of a walk shared by its goroutines.
+type walker struct {
+	ctx          context.Context
+	cancel       context.CancelFunc
+	walkFn       WalkAtFunc
+	openDir      func(*os.File, string) (*os.File, error)
+	queue        chan *walkArgs
+	backlogMu    sync.Mutex
+	backlog      []*walkArgs // Directories which did not fit in queue.
+	skipped      sync.Map    // Directories for which fs.SkipDir was requested.
+	hasSkipped   atomic.Bool
+	errs         []error
+	pending      sync.WaitGroup // Entries submitted but not yet processed.
+	maxErrors    int
+	mu           sync.Mutex
+	reportErrors bool
+	postOrder    bool
+	keepDirs     bool // Whether walkFn or openDir need the parent directory.
+}
+
+// submit queues t to be processed by any worker. If the queue is full,
+// so that workers never block on each other, a directory is put on the
+// backlog, and any other entry is processed right away by the caller.
+// Thus, the memory used grows with the number of directories pending,
+// but not with the width of directories, and a directory is never read
+// while the caller still has its parent open, so the number of open
+// directories does not grow with the depth of the tree.
+func (w *walker) submit(t *walkArgs) {
+	w.pending.Add(1)
+	select {
+	case w.queue <- t:
+		return
+	default:
+	}
+	if t.entry.IsDir() {
+		w.backlogMu.Lock()
+		w.backlog = append(w.backlog, t)
+		w.backlogMu.Unlock()
+		return
+	}
+	w.process(t)
+	w.pending.Done()
+}
+
+// drain moves directories from the backlog to the queue, as long as there
+// is room. It is called by a worker after processing every entry, which
+// is enough for the backlog to never be left behind: a directory is only
+// put on it by a worker while processing an entry, and only when the
+// queue is full, that is, when there are more entries to be processed.
+// The directories added last are moved first, to keep the walk closer to
+// depth-first, and thus the backlog shorter.
+func (w *walker) drain() {
+	w.backlogMu.Lock()
+	defer w.backlogMu.Unlock()
+	for n := len(w.backlog); n > 0; n-- {
+		select {
+		case w.queue <- w.backlog[n-1]:
+			w.backlog[n-1] = nil
+			w.backlog = w.backlog[:n-1]
+		default:
+			return
+		}
+	}
+}
+
+// process calls walkFn for t, and, if t is a directory, reads it and
+// submits its entries.
+func (w *walker) process(t *walkArgs) {
+	if w.stopped() || (!t.root && w.isSkipped(t.path)) {
+		t.dir.release()
+		t.parent.done(w)
+		return
+	}
+	if !t.entry.IsDir() {
+		w.handleFile(t.path, w.walkFn(t.dir.get(), t.path, t.entry, nil))
+		t.dir.release()
+		t.parent.done(w)
+		return
+	}
+	if w.postOrder {
+		node := &dirNode{args: t, parent: t.parent}
+		node.pending.Store(1)
+		if !w.readDir(t, node) {
+			node.skip.Store(true)
+		}
+		// This also releases t.dir and notifies the parent, once all
+		// entries are processed.
+		node.done(w)
+		return
+	}
+	defer t.dir.release()
+	if !t.root && !w.handleDir(w.walkFn(t.dir.get(), t.path, t.entry, nil)) {
+		return
+	}
+	w.readDir(t, nil)
+}
+
+// readDir reads the directory dir in batches and submits its entries,
+// with node as their parent. It returns false if, after an error reading
+// dir, walkFn requested to skip it.
+func (w *walker) readDir(dir *walkArgs, node *dirNode) bool {
+	f, err := w.open(dir)
+	var h *dirHandle
+	if err == nil {
+		if w.keepDirs {
+			h = &dirHandle{file: f}
+			h.refs.Store(1)
+			defer h.release()
+		} else {
+			defer f.Close()
+		}
+	}
+	for err == nil {
+		var entries []fs.DirEntry
+		entries, err = f.ReadDir(readDirBatch)
+		for _, e := range entries {
+			if w.stopped() || w.isSkippedDir(dir.path) {
+				return true
+			}
+			if node != nil {
+				node.pending.Add(1)
+			}
+			w.submit(&walkArgs{path: filepath.Join(dir.path, e.Name()), entry: e, dir: h.acquire(), parent: node})
+		}
+	}
+	if errors.Is(err, io.EOF) || w.stopped() {
+		return true
+	}
+	// Walking a file tree can race with removal,
+	// so ignore ENOENT, except for root.
+	// https://github.com/opencontainers/selinux/issues/199.
+	if errors.Is(err, fs.ErrNotExist) && !dir.root {
+		return true
+	}
+	if w.reportErrors {
+		return w.handleDir(w.walkFn(dir.dir.get(), dir.path, dir.entry, err))
+	}
+	w.addError(err)
+	return true
+}
+
+// open opens the directory dir for reading.
+func (w *walker) open(dir *walkArgs) (*os.File, error) {
+	if w.openDir == nil {
+		return os.Open(dir.path)
+	}
+	if dir.root {
+		return w.openDir(nil, dir.path)
+	}
+	return w.openDir(dir.dir.get(), dir.entry.Name())
+}
+
+func (w *walker) stopped() bool {
+	return w.ctx.Err() != nil
+}
+
+// isSkipped reports whether the parent directory of p was skipped.
+func (w *walker) isSkipped(p string) bool {
+	if !w.hasSkipped.Load() {
+		return false
+	}
+	return w.isSkippedDir(filepath.Dir(p))
+}
+
+// isSkippedDir reports whether the directory p was skipped.
+func (w *walker) isSkippedDir(p string) bool {
+	if !w.hasSkipped.Load() {
+		return false
+	}
+	_, ok := w.skipped.Load(p)
+	return ok
+}
+
+// addError collects err and stops the walk if there are enough errors.
+func (w *walker) addError(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.errs = append(w.errs, err)
+	if w.maxErrors > 0 && len(w.errs) >= w.maxErrors {
+		w.cancel()
+	}
+}
+
+// handleDir handles the result of a walkFn called for a directory,
+// and reports whether the walk should descend into it.
+func (w *walker) handleDir(err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, fs.SkipDir):
+	case errors.Is(err, fs.SkipAll):
+		w.cancel()
+	default:
+		w.addError(err)
+	}
+	return false
+}
+
+// handleFile handles the result of a walkFn called for the non-directory
+// entry p.
+func (w *walker) handleFile(p string, err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, fs.SkipDir):
+		w.skipped.Store(filepath.Dir(p), struct{}{})
+		w.hasSkipped.Store(true)
+	case errors.Is(err, fs.SkipAll):
+		w.cancel()
+	default:
+		w.addError(err)
+	}
+}
+
+// result returns the errors collected during the walk, along with
+// the error of the parent context, if any.
+func (w *walker) result(parent context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	errs := w.errs
+	if err := parent.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errors.Join(errs...)
 }
@@ -1,12 +1,15 @@
 package pwalkdir
 
 import (
+	"context"
 	"errors"
 	"io/fs"
 	"math/rand/v2"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -92,6 +95,322 @@ func TestWalkDirManyErrors(t *testing.T) {
 	}
 }
 
+func TestWalkWithOptionsSkipDir(t *testing.T) {
+	var ac atomic.Uint32
+	dir, total := prepareTestSet(t, 3, 2, 1)
+
+	// Skip everything below the first level.
+	err := WalkWithOptions(context.Background(), dir,
+		func(p string, e fs.DirEntry, _ error) error {
+			ac.Add(1)
+			if e.IsDir() && p != dir {
+				return fs.SkipDir
+			}
+			return nil
+		}, nil)
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	// Root, plus 2 dirs on the first level.
+	if count := ac.Load(); count != 3 {
+		t.Errorf("File count mismatch: found %d, expected 3 (of %d)", count, total)
+	}
+}
+
+func TestWalkWithOptionsSkipAll(t *testing.T) {
+	var ac atomic.Uint32
+	dir, total := prepareTestSet(t, 3, 2, 1)
+
+	err := WalkWithOptions(context.Background(), dir,
+		func(_ string, e fs.DirEntry, _ error) error {
+			ac.Add(1)
+			if e.IsDir() {
+				return fs.SkipAll
+			}
+			return nil
+		}, nil)
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	if count := ac.Load(); count >= total {
+		t.Errorf("SkipAll did not stop the walk: found %d of %d", count, total)
+	}
+}
+
+func TestWalkWithOptionsAllErrors(t *testing.T) {
+	dir, total := prepareTestSet(t, 2, 2, 2)
+
+	e42 := errors.New("42")
+	err := WalkWithOptions(context.Background(), dir,
+		func(_ string, _ fs.DirEntry, _ error) error {
+			return e42
+		}, &Options{MaxErrors: -1})
+	if !errors.Is(err, e42) {
+		t.Fatalf("want error %v, got %v", e42, err)
+	}
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) {
+		t.Fatalf("want joined errors, got %T", err)
+	}
+	// An error for a directory prevents walking into it, so only the
+	// two directories on the first level, and the root, are visited.
+	if n, want := len(joined.Unwrap()), 3; n != want {
+		t.Errorf("want %d errors, got %d (of %d entries)", want, n, total)
+	}
+}
+
+func TestWalkWithOptionsMaxErrors(t *testing.T) {
+	dir, _ := prepareTestSet(t, 2, 3, 5)
+
+	var ac atomic.Uint32
+	err := WalkWithOptions(context.Background(), dir,
+		func(_ string, e fs.DirEntry, _ error) error {
+			if e.IsDir() {
+				return nil
+			}
+			ac.Add(1)
+			return errors.New("file")
+		}, &Options{MaxErrors: 3, Concurrency: 1})
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) {
+		t.Fatalf("want joined errors, got %v", err)
+	}
+	if n := len(joined.Unwrap()); n != 3 {
+		t.Errorf("want 3 errors, got %d", n)
+	}
+}
+
+func TestWalkWithOptionsReportErrors(t *testing.T) {
+	var called atomic.Bool
+	err := WalkWithOptions(context.Background(), "non-existent-directory",
+		func(_ string, _ fs.DirEntry, err error) error {
+			called.Store(true)
+			if !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("want ErrNotExist, got %v", err)
+			}
+			return nil
+		}, &Options{ReportErrors: true})
+	if err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	if !called.Load() {
+		t.Error("walkFn was not called with an error")
+	}
+
+	err = WalkWithOptions(context.Background(), "non-existent-directory", cbEmpty, nil)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("want ErrNotExist, got %v", err)
+	}
+}
+
+func TestWalkWithOptionsCancel(t *testing.T) {
+	dir, total := prepareTestSet(t, 3, 3, 3)
+
+	var ac atomic.Uint32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := WalkWithOptions(ctx, dir,
+		func(_ string, _ fs.DirEntry, _ error) error {
+			if ac.Add(1) == 5 {
+				cancel()
+			}
+			return nil
+		}, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+	if count := ac.Load(); count >= total {
+		t.Errorf("cancel did not stop the walk: found %d of %d", count, total)
+	}
+}
+
+func TestWalkWithOptionsRootLast(t *testing.T) {
+	dir, total := prepareTestSet(t, 2, 2, 2)
+
+	var ac atomic.Uint32
+	err := WalkWithOptions(context.Background(), dir,
+		func(p string, _ fs.DirEntry, _ error) error {
+			n := ac.Add(1)
+			if p == dir && n != total {
+				t.Errorf("root visited as %d of %d", n, total)
+			}
+			if p != dir && !strings.HasPrefix(p, dir) {
+				t.Errorf("unexpected path %q", p)
+			}
+			return nil
+		}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWalkWithOptionsPostOrder(t *testing.T) {
+	dir, total := prepareTestSet(t, 3, 3, 2)
+
+	var (
+		ac   atomic.Uint32
+		done sync.Map
+	)
+	err := WalkWithOptions(context.Background(), dir,
+		func(p string, e fs.DirEntry, _ error) error {
+			ac.Add(1)
+			if e.IsDir() {
+				entries, err := os.ReadDir(p)
+				if err != nil {
+					return err
+				}
+				for _, c := range entries {
+					if _, ok := done.Load(filepath.Join(p, c.Name())); !ok {
+						t.Errorf("%s processed before its entry %s", p, c.Name())
+					}
+				}
+			} else {
+				cbRandomSleep(p, e, nil)
+			}
+			done.Store(p, struct{}{})
+			return nil
+		}, &Options{PostOrder: true, Concurrency: 16})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	if count := ac.Load(); count != total {
+		t.Errorf("File count mismatch: found %d, expected %d", count, total)
+	}
+}
+
+func TestWalkWithOptionsPostOrderSkipAll(t *testing.T) {
+	dir, total := prepareTestSet(t, 3, 3, 2)
+
+	var ac atomic.Uint32
+	err := WalkWithOptions(context.Background(), dir,
+		func(_ string, _ fs.DirEntry, _ error) error {
+			if ac.Add(1) == 10 {
+				return fs.SkipAll
+			}
+			return nil
+		}, &Options{PostOrder: true})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	if count := ac.Load(); count >= total {
+		t.Errorf("SkipAll did not stop the walk: found %d of %d", count, total)
+	}
+}
+
+func TestWalkAt(t *testing.T) {
+	dir, total := prepareTestSet(t, 3, 3, 2)
+
+	for _, postOrder := range []bool{false, true} {
+		var (
+			ac     atomic.Uint32
+			opened atomic.Uint32
+			closed sync.Map
+		)
+		openDir := func(d *os.File, name string) (*os.File, error) {
+			opened.Add(1)
+			if d == nil {
+				return os.Open(name)
+			}
+			return os.Open(filepath.Join(d.Name(), name))
+		}
+		err := WalkAt(context.Background(), dir,
+			func(d *os.File, p string, e fs.DirEntry, _ error) error {
+				ac.Add(1)
+				if p == dir {
+					if d != nil {
+						t.Errorf("root: want nil dir, got %s", d.Name())
+					}
+					return nil
+				}
+				if d == nil || filepath.Join(d.Name(), e.Name()) != p {
+					t.Errorf("%s: unexpected dir %v", p, d)
+					return nil
+				}
+				// The parent directory must still be open.
+				if _, err := d.Stat(); err != nil {
+					t.Errorf("%s: %v", p, err)
+				}
+				closed.Store(d, struct{}{})
+				return nil
+			}, &Options{PostOrder: postOrder, OpenDir: openDir})
+		if err != nil {
+			t.Fatalf("Walk failed: %v", err)
+		}
+		if count := ac.Load(); count != total {
+			t.Errorf("File count mismatch: found %d, expected %d", count, total)
+		}
+		// Every directory except leaves is passed as a parent, once opened.
+		var parents uint32
+		closed.Range(func(k, _ any) bool {
+			parents++
+			if _, err := k.(*os.File).Stat(); !errors.Is(err, os.ErrClosed) {
+				t.Errorf("%s was not closed", k.(*os.File).Name())
+			}
+			return true
+		})
+		if parents == 0 || parents > opened.Load() {
+			t.Errorf("%d parents seen, %d directories opened", parents, opened.Load())
+		}
+	}
+}
+
+func TestWalkDeepTree(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("can not count open file descriptors:", err)
+	}
+	const depth = 400
+	root := t.TempDir()
+	p := root
+	for range depth {
+		// Files fill the queue, so that subdirectories would be read
+		// right away, if it was not for the backlog.
+		for _, f := range []string{"a", "b", "c"} {
+			if err := os.WriteFile(filepath.Join(p, f), nil, 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		p = filepath.Join(p, "d")
+		if err := os.Mkdir(p, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	countFds := func() int {
+		fds, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Error(err)
+		}
+		return len(fds)
+	}
+
+	base := countFds()
+	for _, postOrder := range []bool{false, true} {
+		var (
+			mu     sync.Mutex
+			maxFds int
+			count  int
+		)
+		err := WalkWithOptions(context.Background(), root, func(_ string, _ fs.DirEntry, _ error) error {
+			n := countFds()
+			mu.Lock()
+			defer mu.Unlock()
+			maxFds = max(maxFds, n)
+			count++
+			return nil
+		}, &Options{Concurrency: 2, PostOrder: postOrder})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 4*depth+1 {
+			t.Errorf("postOrder=%v: want %d entries, got %d", postOrder, 4*depth+1, count)
+		}
+		// Each worker has at most one directory open, plus one for
+		// reading /proc/self/fd.
+		if maxFds-base > 8 {
+			t.Errorf("postOrder=%v: open file descriptors grow with depth: %d, had %d", postOrder, maxFds, base)
+		}
+	}
+}
+
 func makeManyDirs(prefix string, levels, dirs, files int) (count uint32, err error) {
 	for range dirs {
 		var dir string
@@ -207,10 +526,131 @@ func BenchmarkWalk(b *testing.B) {
 	}
 }
 
+// BenchmarkWalkTrees compares the implementation with filepath.WalkDir
+// and with the previous implementation (walkNSerialRead), which uses a
+// single goroutine to read directories, on wide and deep trees.
+func BenchmarkWalkTrees(b *testing.B) {
+	trees := []struct {
+		name                string
+		levels, dirs, files int
+	}{
+		{name: "Wide", levels: 0, dirs: 4, files: 5000},
+		{name: "Deep", levels: 12, dirs: 1, files: 20},
+		{name: "Bushy", levels: 4, dirs: 5, files: 10},
+	}
+
+	n := runtime.NumCPU() * 2
+	walkers := []struct {
+		walker walkerFunc
+		name   string
+	}{
+		{name: "filepath.WalkDir", walker: filepath.WalkDir},
+		{name: "serialRead", walker: func(root string, walkFn fs.WalkDirFunc) error {
+			return walkNSerialRead(root, walkFn, n)
+		}},
+		{name: "pwalkdir.Walk", walker: Walk},
+	}
+
+	for _, tree := range trees {
+		dir, total := prepareTestSet(b, tree.levels, tree.dirs, tree.files)
+		b.Logf("%s: %d levels x %d dirs x %d files, total entries: %d", tree.name, tree.levels, tree.dirs, tree.files, total)
+		for _, bm := range []struct {
+			walk fs.WalkDirFunc
+			name string
+		}{
+			{name: "Empty", walk: cbEmpty},
+			{name: "Lstat", walk: cbLstat},
+		} {
+			for _, w := range walkers {
+				walker, walkFn := w.walker, bm.walk
+				b.Run(tree.name+"/"+bm.name+"/"+w.name, func(b *testing.B) {
+					for i := 0; i < b.N; i++ {
+						if err := walker(dir, walkFn); err != nil {
+							b.Errorf("walk failed: %v", err)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+// walkNSerialRead is the previous implementation of WalkN, which walks
+// the tree using filepath.WalkDir in a single goroutine, and only calls
+// walkFn in parallel. It is kept for benchmarking.
+func walkNSerialRead(root string, walkFn fs.WalkDirFunc, num int) error {
+	files := make(chan *walkArgs, 2*num)
+	errCh := make(chan error, 1) // Get the first error, ignore others.
+
+	var (
+		err error
+		wg  sync.WaitGroup
+
+		rootLen   = len(root)
+		rootEntry *walkArgs
+	)
+	wg.Add(1)
+	go func() {
+		err = filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) && len(p) != rootLen {
+					return nil
+				}
+				close(files)
+				return err
+			}
+			if len(p) == rootLen {
+				rootEntry = &walkArgs{path: p, entry: entry}
+				return nil
+			}
+			select {
+			case e := <-errCh:
+				close(files)
+				return e
+			default:
+				files <- &walkArgs{path: p, entry: entry}
+				return nil
+			}
+		})
+		if err == nil {
+			close(files)
+		}
+		wg.Done()
+	}()
+
+	wg.Add(num)
+	for range num {
+		go func() {
+			for file := range files {
+				if e := walkFn(file.path, file.entry, nil); e != nil {
+					select {
+					case errCh <- e:
+					default:
+					}
+				}
+			}
+			wg.Done()
+		}()
+	}
+
+	wg.Wait()
+
+	if err == nil {
+		err = walkFn(rootEntry.path, rootEntry.entry, nil)
+	}
+
+	return err
+}
+
 func cbEmpty(_ string, _ fs.DirEntry, _ error) error {
 	return nil
 }
 
+func cbLstat(path string, _ fs.DirEntry, _ error) error {
+	_, err := os.Lstat(path)
+	return err
+}
+
 func cbChownChmod(path string, e fs.DirEntry, _ error) error {
 	_ = os.Chown(path, 0, 0)
 	mode := os.FileMode(0o644)